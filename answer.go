@@ -0,0 +1,223 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/Masterminds/sprig"
+)
+
+// AnswerFormat describes an unattended installer answer file and the secondary ISO the installer reads it from.
+type AnswerFormat struct {
+	Name     string
+	FileName string
+	VolumeID string
+	Template string
+	Note     string
+}
+
+var answerFormats = map[string]AnswerFormat{
+	"autoinstall": {
+		Name:     "autoinstall",
+		FileName: "user-data",
+		VolumeID: "cidata",
+		Template: DefaultAutoinstallTemplate,
+		Note:     "Ubuntu releases before 22.10 ask for confirmation unless 'autoinstall' is added to the installer kernel command line.",
+	},
+	"kickstart": {
+		Name:     "kickstart",
+		FileName: "ks.cfg",
+		VolumeID: "OEMDRV",
+		Template: DefaultKickstartTemplate,
+	},
+	"preseed": {
+		Name:     "preseed",
+		FileName: "preseed.cfg",
+		VolumeID: "PRESEED",
+		Template: DefaultPreseedTemplate,
+		Note:     "The Debian installer does not search secondary media for a preseed file, add preseed.cfg from the PRESEED volume to the installer ISO or serve it and boot with 'auto=true priority=critical preseed/url=...'.",
+	},
+	"autounattend": {
+		Name:     "autounattend",
+		FileName: "autounattend.xml",
+		VolumeID: "UNATTEND",
+		Template: DefaultAutounattendTemplate,
+	},
+}
+
+var diskLayouts = []string{"lvm", "plain"}
+
+var productKeyRegex = regexp.MustCompile(`^[0-9A-Z]{5}(-[0-9A-Z]{5}){4}$`)
+
+type AnswerConfig struct {
+	Format            string
+	TemplateFile      string
+	Hostname          string
+	TS                int64
+	Username          string
+	Password          string
+	AuthorizedKeyFile string
+	Timezone          string
+	Locale            string
+	Keyboard          string
+	DiskLayout        string
+	UEFI              bool
+
+	// Windows image in the install.wim of the ISO, by index or name, and product key for autounattend.
+	ImageIndex int
+	ImageName  string
+	ProductKey string
+}
+
+func answerFormatNames() []string {
+	var names []string
+	for n := range answerFormats {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// parseWindowsImage parses a Windows image selection, a number is an image index, anything else an image name.
+func parseWindowsImage(s string) (int, string, error) {
+	if n, err := strconv.Atoi(s); err == nil {
+		if n < 1 {
+			return 0, "", fmt.Errorf("invalid Windows image index %d, indexes start at 1", n)
+		}
+		return n, "", nil
+	}
+	return 0, s, nil
+}
+
+func lookupAnswerFormat(name string) (AnswerFormat, error) {
+	f, ok := answerFormats[name]
+	if !ok {
+		return f, fmt.Errorf("invalid answer file format '%s', valid values are: %s", name, strings.Join(answerFormatNames(), ", "))
+	}
+	return f, nil
+}
+
+// makeAnswerISO renders the answer file for cfg.Format in dir and packs it into an ISO with the
+// volume label the installer looks for. Returns the path of the ISO.
+func makeAnswerISO(dir string, cfg AnswerConfig) (string, error) {
+	format, err := lookupAnswerFormat(cfg.Format)
+	if err != nil {
+		return "", err
+	}
+	if !stringInSlice(cfg.DiskLayout, diskLayouts) {
+		return "", fmt.Errorf("invalid disk layout '%s', valid values are: %s", cfg.DiskLayout, strings.Join(diskLayouts, ", "))
+	}
+	if cfg.ProductKey != "" && !productKeyRegex.MatchString(cfg.ProductKey) {
+		return "", fmt.Errorf("invalid product key '%s', expected XXXXX-XXXXX-XXXXX-XXXXX-XXXXX", cfg.ProductKey)
+	}
+
+	answerFile, err := renderAnswerFile(dir, format, cfg)
+	if err != nil {
+		return "", err
+	}
+	files := []string{answerFile}
+
+	// The NoCloud datasource used by autoinstall needs meta-data next to user-data.
+	if format.VolumeID == "cidata" {
+		metaDataFile := filepath.Join(dir, "meta-data")
+		if err := ioutil.WriteFile(metaDataFile, []byte(fmt.Sprintf(DefaultMetaData, cfg.Hostname, cfg.TS, cfg.Hostname)), 0644); err != nil {
+			return "", err
+		}
+		files = append(files, metaDataFile)
+	}
+
+	if format.Note != "" {
+		log.Printf("INFO: %s", format.Note)
+	}
+
+	isoFile := filepath.Join(dir, fmt.Sprintf("answer_%d.iso", cfg.TS))
+	if err := makeISO(isoFile, format.VolumeID, files...); err != nil {
+		return "", err
+	}
+	return isoFile, nil
+}
+
+// renderAnswerFile renders the answer file of format with the values of cfg into dir and returns its path.
+func renderAnswerFile(dir string, format AnswerFormat, cfg AnswerConfig) (string, error) {
+	tmpl := format.Template
+	if cfg.TemplateFile != "" {
+		data, err := ioutil.ReadFile(cfg.TemplateFile)
+		if err != nil {
+			return "", err
+		}
+		tmpl = string(data)
+	}
+	t, err := template.New(format.FileName).Funcs(sprig.TxtFuncMap()).Funcs(template.FuncMap{"xml": html.EscapeString}).Parse(tmpl)
+	if err != nil {
+		return "", fmt.Errorf("error parsing %s template: %v", format.Name, err)
+	}
+
+	// SSH key is optional for installers, unlike for cloud-init.
+	var authKey string
+	if data, err := ioutil.ReadFile(cfg.AuthorizedKeyFile); err == nil {
+		authKey = strings.TrimSpace(string(data))
+	} else if cfg.AuthorizedKeyFile != "" {
+		log.Printf("WARN: could not read %s, the answer file will not include an SSH key: %v", cfg.AuthorizedKeyFile, err)
+	}
+
+	passwordHash, err := hashPassword(cfg.Password)
+	if err != nil {
+		return "", err
+	}
+
+	type tmplData struct {
+		Hostname      string
+		Username      string
+		Password      string
+		PasswordHash  string
+		AuthorizedKey string
+		Timezone      string
+		Locale        string
+		WindowsLocale string
+		Keyboard      string
+		DiskLayout    string
+		UEFI          bool
+		ImageIndex    int
+		ImageName     string
+		ProductKey    string
+	}
+	data := tmplData{
+		Hostname:      cfg.Hostname,
+		Username:      cfg.Username,
+		Password:      cfg.Password,
+		PasswordHash:  passwordHash,
+		AuthorizedKey: authKey,
+		Timezone:      cfg.Timezone,
+		Locale:        cfg.Locale,
+		WindowsLocale: strings.Replace(strings.SplitN(cfg.Locale, ".", 2)[0], "_", "-", -1),
+		Keyboard:      cfg.Keyboard,
+		DiskLayout:    cfg.DiskLayout,
+		UEFI:          cfg.UEFI,
+		ImageIndex:    cfg.ImageIndex,
+		ImageName:     cfg.ImageName,
+		ProductKey:    cfg.ProductKey,
+	}
+
+	answerFile := filepath.Join(dir, format.FileName)
+	f, err := os.Create(answerFile)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if err := t.Execute(f, data); err != nil {
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+	return answerFile, nil
+}
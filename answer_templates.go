@@ -0,0 +1,232 @@
+package main
+
+const DefaultAutoinstallTemplate = `#cloud-config
+autoinstall:
+  version: 1
+  locale: {{.Locale}}
+  keyboard:
+    layout: {{.Keyboard}}
+  timezone: {{.Timezone}}
+  identity:
+    hostname: {{.Hostname}}
+    username: {{.Username}}
+    password: "{{.PasswordHash}}"
+  ssh:
+    install-server: true
+    allow-pw: true
+{{- if .AuthorizedKey}}
+    authorized-keys:
+    - {{.AuthorizedKey}}
+{{- end}}
+  storage:
+    layout:
+      name: {{if eq .DiskLayout "lvm"}}lvm{{else}}direct{{end}}
+  shutdown: reboot
+`
+
+const DefaultKickstartTemplate = `text
+lang {{.Locale}}
+keyboard {{.Keyboard}}
+timezone {{.Timezone}} --utc
+network --bootproto=dhcp --hostname={{.Hostname}} --activate
+rootpw --lock
+user --name={{.Username}} --groups=wheel --iscrypted --password={{.PasswordHash}}
+{{- if .AuthorizedKey}}
+sshkey --username={{.Username}} "{{.AuthorizedKey}}"
+{{- end}}
+zerombr
+clearpart --all --initlabel
+autopart --type={{if eq .DiskLayout "lvm"}}lvm{{else}}plain{{end}}
+bootloader
+firstboot --disabled
+services --enabled=sshd
+reboot
+
+%packages
+@core
+openssh-server
+%end
+`
+
+const DefaultPreseedTemplate = `d-i debian-installer/locale string {{.Locale}}
+d-i keyboard-configuration/xkb-keymap select {{.Keyboard}}
+d-i netcfg/choose_interface select auto
+d-i netcfg/get_hostname string {{.Hostname}}
+d-i netcfg/get_domain string
+d-i netcfg/hostname string {{.Hostname}}
+d-i mirror/country string manual
+d-i mirror/http/hostname string deb.debian.org
+d-i mirror/http/directory string /debian
+d-i mirror/http/proxy string
+d-i passwd/root-login boolean false
+d-i passwd/user-fullname string {{.Username}}
+d-i passwd/username string {{.Username}}
+d-i passwd/user-password-crypted password {{.PasswordHash}}
+d-i clock-setup/utc boolean true
+d-i time/zone string {{.Timezone}}
+d-i partman-auto/method string {{if eq .DiskLayout "lvm"}}lvm{{else}}regular{{end}}
+d-i partman-auto-lvm/guided_size string max
+d-i partman-lvm/device_remove_lvm boolean true
+d-i partman-lvm/confirm boolean true
+d-i partman-lvm/confirm_nooverwrite boolean true
+d-i partman-auto/choose_recipe select atomic
+d-i partman-partitioning/confirm_write_new_label boolean true
+d-i partman/choose_partition select finish
+d-i partman/confirm boolean true
+d-i partman/confirm_nooverwrite boolean true
+tasksel tasksel/first multiselect standard, ssh-server
+d-i pkgsel/include string sudo
+popularity-contest popularity-contest/participate boolean false
+d-i grub-installer/only_debian boolean true
+d-i grub-installer/bootdev string default
+{{- if .AuthorizedKey}}
+d-i preseed/late_command string in-target sh -c 'mkdir -p /home/{{.Username}}/.ssh && echo "{{.AuthorizedKey}}" > /home/{{.Username}}/.ssh/authorized_keys && chmod 700 /home/{{.Username}}/.ssh && chmod 600 /home/{{.Username}}/.ssh/authorized_keys && chown -R {{.Username}}:{{.Username}} /home/{{.Username}}/.ssh'
+{{- end}}
+d-i finish-install/reboot_in_progress note
+`
+
+const DefaultAutounattendTemplate = `<?xml version="1.0" encoding="utf-8"?>
+<unattend xmlns="urn:schemas-microsoft-com:unattend" xmlns:wcm="http://schemas.microsoft.com/WMIConfig/2002/State">
+  <settings pass="windowsPE">
+    <component name="Microsoft-Windows-International-Core-WinPE" processorArchitecture="amd64" publicKeyToken="31bf3856ad364e35" language="neutral" versionScope="nonSxS">
+      <SetupUILanguage>
+        <UILanguage>{{.WindowsLocale}}</UILanguage>
+      </SetupUILanguage>
+      <InputLocale>{{.WindowsLocale}}</InputLocale>
+      <SystemLocale>{{.WindowsLocale}}</SystemLocale>
+      <UILanguage>{{.WindowsLocale}}</UILanguage>
+      <UserLocale>{{.WindowsLocale}}</UserLocale>
+    </component>
+    <component name="Microsoft-Windows-Setup" processorArchitecture="amd64" publicKeyToken="31bf3856ad364e35" language="neutral" versionScope="nonSxS">
+      <DiskConfiguration>
+        <Disk wcm:action="add">
+          <DiskID>0</DiskID>
+          <WillWipeDisk>true</WillWipeDisk>
+          <CreatePartitions>
+{{- if .UEFI}}
+            <CreatePartition wcm:action="add">
+              <Order>1</Order>
+              <Type>EFI</Type>
+              <Size>100</Size>
+            </CreatePartition>
+            <CreatePartition wcm:action="add">
+              <Order>2</Order>
+              <Type>MSR</Type>
+              <Size>16</Size>
+            </CreatePartition>
+            <CreatePartition wcm:action="add">
+              <Order>3</Order>
+              <Type>Primary</Type>
+              <Extend>true</Extend>
+            </CreatePartition>
+{{- else}}
+            <CreatePartition wcm:action="add">
+              <Order>1</Order>
+              <Type>Primary</Type>
+              <Size>500</Size>
+            </CreatePartition>
+            <CreatePartition wcm:action="add">
+              <Order>2</Order>
+              <Type>Primary</Type>
+              <Extend>true</Extend>
+            </CreatePartition>
+{{- end}}
+          </CreatePartitions>
+          <ModifyPartitions>
+{{- if .UEFI}}
+            <ModifyPartition wcm:action="add">
+              <Order>1</Order>
+              <PartitionID>1</PartitionID>
+              <Format>FAT32</Format>
+              <Label>System</Label>
+            </ModifyPartition>
+            <ModifyPartition wcm:action="add">
+              <Order>2</Order>
+              <PartitionID>3</PartitionID>
+              <Format>NTFS</Format>
+              <Label>Windows</Label>
+              <Letter>C</Letter>
+            </ModifyPartition>
+{{- else}}
+            <ModifyPartition wcm:action="add">
+              <Order>1</Order>
+              <PartitionID>1</PartitionID>
+              <Format>NTFS</Format>
+              <Label>System Reserved</Label>
+              <Active>true</Active>
+            </ModifyPartition>
+            <ModifyPartition wcm:action="add">
+              <Order>2</Order>
+              <PartitionID>2</PartitionID>
+              <Format>NTFS</Format>
+              <Label>Windows</Label>
+              <Letter>C</Letter>
+            </ModifyPartition>
+{{- end}}
+          </ModifyPartitions>
+        </Disk>
+      </DiskConfiguration>
+      <ImageInstall>
+        <OSImage>
+{{- if .ImageIndex}}
+          <InstallFrom>
+            <MetaData wcm:action="add">
+              <Key>/IMAGE/INDEX</Key>
+              <Value>{{.ImageIndex}}</Value>
+            </MetaData>
+          </InstallFrom>
+{{- else if .ImageName}}
+          <InstallFrom>
+            <MetaData wcm:action="add">
+              <Key>/IMAGE/NAME</Key>
+              <Value>{{.ImageName | xml}}</Value>
+            </MetaData>
+          </InstallFrom>
+{{- end}}
+          <InstallTo>
+            <DiskID>0</DiskID>
+            <PartitionID>{{if .UEFI}}3{{else}}2{{end}}</PartitionID>
+          </InstallTo>
+        </OSImage>
+      </ImageInstall>
+      <UserData>
+        <AcceptEula>true</AcceptEula>
+{{- if .ProductKey}}
+        <ProductKey>
+          <Key>{{.ProductKey | xml}}</Key>
+          <WillShowUI>OnError</WillShowUI>
+        </ProductKey>
+{{- end}}
+      </UserData>
+    </component>
+  </settings>
+  <settings pass="specialize">
+    <component name="Microsoft-Windows-Shell-Setup" processorArchitecture="amd64" publicKeyToken="31bf3856ad364e35" language="neutral" versionScope="nonSxS">
+      <ComputerName>{{.Hostname | trunc 15 | xml}}</ComputerName>
+      <TimeZone>{{.Timezone}}</TimeZone>
+    </component>
+  </settings>
+  <settings pass="oobeSystem">
+    <component name="Microsoft-Windows-Shell-Setup" processorArchitecture="amd64" publicKeyToken="31bf3856ad364e35" language="neutral" versionScope="nonSxS">
+      <OOBE>
+        <HideEULAPage>true</HideEULAPage>
+        <HideOnlineAccountScreens>true</HideOnlineAccountScreens>
+        <HideWirelessSetupInOOBE>true</HideWirelessSetupInOOBE>
+        <ProtectYourPC>3</ProtectYourPC>
+      </OOBE>
+      <UserAccounts>
+        <LocalAccounts>
+          <LocalAccount wcm:action="add">
+            <Name>{{.Username | xml}}</Name>
+            <Group>Administrators</Group>
+            <Password>
+              <Value>{{.Password | xml}}</Value>
+              <PlainText>true</PlainText>
+            </Password>
+          </LocalAccount>
+        </LocalAccounts>
+      </UserAccounts>
+    </component>
+  </settings>
+</unattend>
+`
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseWindowsImage(t *testing.T) {
+	tests := []struct {
+		in        string
+		wantIndex int
+		wantName  string
+		wantErr   bool
+	}{
+		{"", 0, "", false},
+		{"2", 2, "", false},
+		{"Windows 10 Pro", 0, "Windows 10 Pro", false},
+		{"0", 0, "", true},
+		{"-1", 0, "", true},
+	}
+	for _, tt := range tests {
+		index, name, err := parseWindowsImage(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseWindowsImage(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if index != tt.wantIndex || name != tt.wantName {
+			t.Errorf("parseWindowsImage(%q) = %d, %q, want %d, %q", tt.in, index, name, tt.wantIndex, tt.wantName)
+		}
+	}
+}
+
+func TestRenderAutounattend(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     AnswerConfig
+		want    []string
+		notWant []string
+	}{
+		{
+			name:    "escaped values",
+			cfg:     AnswerConfig{Hostname: "web&db", Username: "<admin>", Password: `p"w&'d`},
+			want:    []string{"<ComputerName>web&amp;db</ComputerName>", "<Name>&lt;admin&gt;</Name>", "<Value>p&#34;w&amp;&#39;d</Value>"},
+			notWant: []string{"<InstallFrom>", "<ProductKey>"},
+		},
+		{
+			name: "image index and product key",
+			cfg:  AnswerConfig{Hostname: "win", Username: "admin", Password: "pw", ImageIndex: 2, ProductKey: "VK7JG-NPHTM-C97JM-9MPGT-3V66T"},
+			want: []string{"<Key>/IMAGE/INDEX</Key>", "<Value>2</Value>", "<Key>VK7JG-NPHTM-C97JM-9MPGT-3V66T</Key>"},
+		},
+		{
+			name:    "image name",
+			cfg:     AnswerConfig{Hostname: "win", Username: "admin", Password: "pw", ImageName: "Windows 10 Pro"},
+			want:    []string{"<Key>/IMAGE/NAME</Key>", "<Value>Windows 10 Pro</Value>"},
+			notWant: []string{"/IMAGE/INDEX"},
+		},
+	}
+	format, _ := lookupAnswerFormat("autounattend")
+	for _, tt := range tests {
+		dir, err := ioutil.TempDir("", "answer-test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		tt.cfg.Locale = "en_US.UTF-8"
+		file, err := renderAnswerFile(dir, format, tt.cfg)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, w := range tt.want {
+			if !strings.Contains(string(data), w) {
+				t.Errorf("%s: answer file does not contain %s", tt.name, w)
+			}
+		}
+		for _, w := range tt.notWant {
+			if strings.Contains(string(data), w) {
+				t.Errorf("%s: answer file contains %s", tt.name, w)
+			}
+		}
+	}
+}
+
+func TestRenderPreseed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "answer-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	keyFile := filepath.Join(dir, "id_ed25519.pub")
+	if err := ioutil.WriteFile(keyFile, []byte("ssh-ed25519 AAAA test\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		cfg     AnswerConfig
+		want    []string
+		notWant []string
+	}{
+		{
+			name: "lvm with key",
+			cfg:  AnswerConfig{DiskLayout: "lvm", AuthorizedKeyFile: keyFile},
+			want: []string{
+				"d-i netcfg/hostname string deb",
+				"d-i passwd/username string debian",
+				"d-i passwd/user-password-crypted password $6$",
+				"d-i time/zone string Europe/Berlin",
+				"d-i debian-installer/locale string en_US.UTF-8",
+				"d-i partman-auto/method string lvm",
+				`echo "ssh-ed25519 AAAA test" > /home/debian/.ssh/authorized_keys`,
+			},
+			notWant: []string{"secret"},
+		},
+		{
+			name:    "plain without key",
+			cfg:     AnswerConfig{DiskLayout: "plain"},
+			want:    []string{"d-i partman-auto/method string regular"},
+			notWant: []string{"preseed/late_command"},
+		},
+	}
+	format, err := lookupAnswerFormat("preseed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		tt.cfg.Hostname = "deb"
+		tt.cfg.Username = "debian"
+		tt.cfg.Password = "secret"
+		tt.cfg.Timezone = "Europe/Berlin"
+		tt.cfg.Locale = "en_US.UTF-8"
+		tt.cfg.Keyboard = "us"
+		file, err := renderAnswerFile(dir, format, tt.cfg)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if filepath.Base(file) != "preseed.cfg" {
+			t.Errorf("%s: answer file %s, want preseed.cfg", tt.name, file)
+		}
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, w := range tt.want {
+			if !strings.Contains(string(data), w) {
+				t.Errorf("%s: answer file does not contain %s", tt.name, w)
+			}
+		}
+		for _, w := range tt.notWant {
+			if strings.Contains(string(data), w) {
+				t.Errorf("%s: answer file contains %s", tt.name, w)
+			}
+		}
+	}
+}
+
+func TestLookupAnswerFormat(t *testing.T) {
+	for _, name := range []string{"autoinstall", "kickstart", "preseed", "autounattend"} {
+		if _, err := lookupAnswerFormat(name); err != nil {
+			t.Errorf("lookupAnswerFormat(%q): %v", name, err)
+		}
+	}
+	if _, err := lookupAnswerFormat("jumpstart"); err == nil {
+		t.Error("lookupAnswerFormat(\"jumpstart\"): expected an error")
+	}
+}
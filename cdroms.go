@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// ResolveISO returns the NAS path of an ISO. Local files are uploaded to uploadDir first,
+// anything else must already exist on the NAS.
+func (c *QVSClient) ResolveISO(isoPath string, uploadDir string) (string, error) {
+	if fi, err := os.Stat(isoPath); err == nil && !fi.IsDir() {
+		if err := c.EnsureDir(uploadDir); err != nil {
+			return "", err
+		}
+		f, err := os.Open(isoPath)
+		if err != nil {
+			return "", err
+		}
+		defer f.Close()
+		dest := filepath.Join(uploadDir, filepath.Base(isoPath))
+		log.Printf("INFO: Uploading ISO image to NAS: %s", dest)
+		if err := c.UploadFile(f, dest); err != nil {
+			return "", err
+		}
+		return dest, nil
+	}
+
+	files, err := c.ListDir(filepath.Dir(isoPath))
+	if err != nil {
+		return "", err
+	}
+	for _, f := range files {
+		if f.Filename == filepath.Base(isoPath) && f.IsFolder == 0 {
+			return isoPath, nil
+		}
+	}
+	return "", fmt.Errorf("ISO not found locally or on the NAS: %s", isoPath)
+}
+
+func findVMCDROM(vm VMResponse, index int) (VMCDROMsResponse, error) {
+	for i, cd := range vm.CDROMs {
+		if i == index {
+			return cd, nil
+		}
+	}
+	return VMCDROMsResponse{}, fmt.Errorf("VM %s has no CD-ROM drive %d", vm.Name, index)
+}
+
+// VMEjectISO ejects the ISO at isoPath from every CD-ROM drive of the VM that holds it.
+func (c *QVSClient) VMEjectISO(idOrName string, isoPath string) error {
+	vm, err := c.VMGet(idOrName)
+	if err != nil {
+		return err
+	}
+	found := false
+	for _, cd := range vm.CDROMs {
+		if cd.Path != "" && filepath.Clean(cd.Path) == filepath.Clean(isoPath) {
+			if err := c.VMCDROMUpdate(fmt.Sprintf("%d", vm.ID), cd.ID, map[string]string{"path": ""}); err != nil {
+				return err
+			}
+			found = true
+		}
+	}
+	if !found {
+		return fmt.Errorf("ISO %s is not inserted in VM %s", isoPath, vm.Name)
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+)
+
+// VMConsoleURL returns the URL of the web console of a VM.
+func (c *QVSClient) VMConsoleURL(id string) string {
+	return c.QtsURL + fmt.Sprintf(QVSVNCTpl, id)
+}
+
+// VMVNCDial connects to the VNC server of a running VM, directly on the NAS or through the QVS websocket console.
+func (c *QVSClient) VMVNCDial(vm VMResponse, websocket bool) (net.Conn, error) {
+	if vm.PowerState != QVSPowerStateRunning {
+		return nil, fmt.Errorf("error, VM %s is not running, current state: %s", vm.Name, vm.PowerState)
+	}
+	if websocket {
+		return c.dialWebsocket(fmt.Sprintf(QVSVMConsoleWebsocket, fmt.Sprintf("%d", vm.ID)), "binary")
+	}
+	if len(vm.Graphics) == 0 || vm.Graphics[0].Port <= 0 {
+		return nil, fmt.Errorf("VM %s has no VNC port", vm.Name)
+	}
+	u, err := url.Parse(c.QtsURL)
+	if err != nil {
+		return nil, err
+	}
+	return net.Dial("tcp", net.JoinHostPort(u.Hostname(), fmt.Sprintf("%d", vm.Graphics[0].Port)))
+}
+
+// serveVNCProxy accepts VNC viewer connections on l and tunnels each of them to a new connection from dial.
+// The proxy authenticates to the VM with password, viewers connect without a password.
+func serveVNCProxy(l net.Listener, dial func() (net.Conn, error), password string) error {
+	for {
+		viewer, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			defer viewer.Close()
+			upstream, err := dial()
+			if err != nil {
+				log.Printf("ERROR: connecting to VM console: %v", err)
+				return
+			}
+			defer upstream.Close()
+			if err := rfbClientHandshake(upstream, password); err != nil {
+				log.Printf("ERROR: %v", err)
+				return
+			}
+			if err := rfbServerHandshake(viewer); err != nil {
+				log.Printf("ERROR: VNC viewer handshake: %v", err)
+				return
+			}
+			log.Printf("INFO: VNC viewer connected from %s", viewer.RemoteAddr())
+			done := make(chan struct{}, 2)
+			go func() {
+				io.Copy(upstream, viewer)
+				done <- struct{}{}
+			}()
+			go func() {
+				io.Copy(viewer, upstream)
+				done <- struct{}{}
+			}()
+			<-done
+			log.Printf("INFO: VNC viewer disconnected from %s", viewer.RemoteAddr())
+		}()
+	}
+}
+
+// VMRFBClient opens an authenticated VNC session to the console of a running VM.
+func (c *QVSClient) VMRFBClient(vm VMResponse, websocket bool, password string) (*RFBClient, error) {
+	conn, err := c.VMVNCDial(vm, websocket)
+	if err != nil {
+		return nil, err
+	}
+	rfb, err := newRFBClient(conn, password)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return rfb, nil
+}
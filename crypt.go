@@ -0,0 +1,130 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha512"
+	"fmt"
+)
+
+const cryptAlphabet = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// sha512CryptRounds is the glibc default, which is omitted from the hash string.
+const sha512CryptRounds = 5000
+
+// hashPassword returns a SHA-512 crypt(3) hash ($6$) of password with a random salt,
+// the format expected in /etc/shadow, cloud-init and the installers.
+func hashPassword(password string) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	salt := make([]byte, 16)
+	for i := range b {
+		salt[i] = cryptAlphabet[int(b[i])%len(cryptAlphabet)]
+	}
+	return sha512Crypt(password, string(salt)), nil
+}
+
+// sha512Crypt implements the SHA-512 based crypt(3) algorithm by Ulrich Drepper.
+func sha512Crypt(password string, salt string) string {
+	if len(salt) > 16 {
+		salt = salt[:16]
+	}
+	p := []byte(password)
+	s := []byte(salt)
+
+	b := sha512.New()
+	b.Write(p)
+	b.Write(s)
+	b.Write(p)
+	digestB := b.Sum(nil)
+
+	a := sha512.New()
+	a.Write(p)
+	a.Write(s)
+	for n := len(p); n > 0; n -= 64 {
+		if n > 64 {
+			a.Write(digestB)
+		} else {
+			a.Write(digestB[:n])
+		}
+	}
+	for n := len(p); n > 0; n >>= 1 {
+		if n&1 != 0 {
+			a.Write(digestB)
+		} else {
+			a.Write(p)
+		}
+	}
+	digestA := a.Sum(nil)
+
+	dp := sha512.New()
+	for i := 0; i < len(p); i++ {
+		dp.Write(p)
+	}
+	pSeq := repeatBytes(dp.Sum(nil), len(p))
+
+	ds := sha512.New()
+	for i := 0; i < 16+int(digestA[0]); i++ {
+		ds.Write(s)
+	}
+	sSeq := repeatBytes(ds.Sum(nil), len(s))
+
+	c := digestA
+	for i := 0; i < sha512CryptRounds; i++ {
+		h := sha512.New()
+		if i%2 != 0 {
+			h.Write(pSeq)
+		} else {
+			h.Write(c)
+		}
+		if i%3 != 0 {
+			h.Write(sSeq)
+		}
+		if i%7 != 0 {
+			h.Write(pSeq)
+		}
+		if i%2 != 0 {
+			h.Write(c)
+		} else {
+			h.Write(pSeq)
+		}
+		c = h.Sum(nil)
+	}
+
+	// Byte order of the final encoding is fixed by the algorithm.
+	order := [][3]int{
+		{0, 21, 42}, {22, 43, 1}, {44, 2, 23}, {3, 24, 45}, {25, 46, 4}, {47, 5, 26}, {6, 27, 48},
+		{28, 49, 7}, {50, 8, 29}, {9, 30, 51}, {31, 52, 10}, {53, 11, 32}, {12, 33, 54}, {34, 55, 13},
+		{56, 14, 35}, {15, 36, 57}, {37, 58, 16}, {59, 17, 38}, {18, 39, 60}, {40, 61, 19}, {62, 20, 41},
+	}
+	var out []byte
+	for _, o := range order {
+		out = append(out, crypt64(c[o[0]], c[o[1]], c[o[2]], 4)...)
+	}
+	out = append(out, crypt64(0, 0, c[63], 2)...)
+
+	return fmt.Sprintf("$6$%s$%s", salt, out)
+}
+
+func crypt64(b2, b1, b0 byte, n int) []byte {
+	w := uint(b2)<<16 | uint(b1)<<8 | uint(b0)
+	out := make([]byte, n)
+	for i := 0; i < n; i++ {
+		out[i] = cryptAlphabet[w&0x3f]
+		w >>= 6
+	}
+	return out
+}
+
+func repeatBytes(src []byte, n int) []byte {
+	out := make([]byte, 0, n)
+	for len(out) < n {
+		if n-len(out) >= len(src) {
+			out = append(out, src...)
+		} else {
+			out = append(out, src[:n-len(out)]...)
+		}
+	}
+	return out
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSHA512Crypt(t *testing.T) {
+	// Test vectors of the glibc SHA-crypt specification, with the default number of rounds.
+	tests := []struct {
+		password string
+		salt     string
+		want     string
+	}{
+		{"Hello world!", "saltstring", "$6$saltstring$svn8UoSVapNtMuq1ukKS4tPQd8iKwSMHWjl/O817G3uBnIFNjnQJuesI68u4OTLiBFdcbYEdFCoEOfaS35inz1"},
+	}
+	for _, tt := range tests {
+		if got := sha512Crypt(tt.password, tt.salt); got != tt.want {
+			t.Errorf("sha512Crypt(%q, %q) = %s, want %s", tt.password, tt.salt, got, tt.want)
+		}
+	}
+}
+
+func TestHashPassword(t *testing.T) {
+	h, err := hashPassword("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	toks := strings.Split(h, "$")
+	if len(toks) != 4 || toks[1] != "6" || len(toks[2]) != 16 {
+		t.Fatalf("hashPassword = %s, want $6$<16 character salt>$<hash>", h)
+	}
+	if sha512Crypt("secret", toks[2]) != h {
+		t.Errorf("hashPassword = %s does not verify with its salt", h)
+	}
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+const defaultDiskBus = "virtio"
+
+var diskBuses = []string{"virtio", "scsi", "sata", "ide"}
+var diskCaches = []string{"none", "writeback", "writethrough", "directsync", "unsafe"}
+var diskFormats = []string{"qcow2", "raw"}
+
+type DataDisk struct {
+	Size       uint64
+	Bus        string
+	Cache      string
+	Format     string
+	FS         string
+	MountPoint string
+	Label      string
+	Dev        string
+}
+
+// parseKeyValues parses comma separated key=value pairs such as 'size=100G,bus=virtio'.
+func parseKeyValues(spec string, allowed []string) (map[string]string, error) {
+	kv := make(map[string]string)
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		toks := strings.SplitN(part, "=", 2)
+		if len(toks) != 2 {
+			return nil, fmt.Errorf("invalid option '%s' in '%s', expected key=value", part, spec)
+		}
+		key := strings.TrimSpace(toks[0])
+		if !stringInSlice(key, allowed) {
+			return nil, fmt.Errorf("unknown option '%s' in '%s', valid options are: %s", key, spec, strings.Join(allowed, ", "))
+		}
+		kv[key] = strings.TrimSpace(toks[1])
+	}
+	return kv, nil
+}
+
+func stringInSlice(s string, list []string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// parseDataDisk parses a --data-disk spec: size=100G,bus=virtio,cache=none,format=qcow2,fs=ext4,mount=/data
+func parseDataDisk(spec string, index int) (DataDisk, error) {
+	kv, err := parseKeyValues(spec, []string{"size", "bus", "cache", "format", "fs", "mount"})
+	if err != nil {
+		return DataDisk{}, err
+	}
+	d := DataDisk{
+		Cache:      "none",
+		Format:     "qcow2",
+		FS:         "ext4",
+		MountPoint: fmt.Sprintf("/mnt/data%d", index),
+		Label:      fmt.Sprintf("data%d", index),
+	}
+	if kv["size"] == "" {
+		return d, fmt.Errorf("data disk size is required: %s", spec)
+	}
+	if d.Size, err = parseSize(kv["size"]); err != nil {
+		return d, err
+	}
+	if v, ok := kv["bus"]; ok {
+		d.Bus = v
+	}
+	if v, ok := kv["cache"]; ok {
+		d.Cache = v
+	}
+	if v, ok := kv["format"]; ok {
+		d.Format = v
+	}
+	if v, ok := kv["fs"]; ok {
+		d.FS = v
+	}
+	if v, ok := kv["mount"]; ok {
+		d.MountPoint = v
+	}
+	if err := validateDiskOptions(d.Bus, d.Cache, d.Format); err != nil {
+		return d, err
+	}
+	if d.MountPoint != "none" && !filepath.IsAbs(d.MountPoint) {
+		return d, fmt.Errorf("data disk mount point must be an absolute path or 'none': %s", d.MountPoint)
+	}
+	return d, nil
+}
+
+func validateDiskOptions(bus, cache, format string) error {
+	if bus != "" && !stringInSlice(bus, diskBuses) {
+		return fmt.Errorf("invalid disk bus '%s', valid values are: %s", bus, strings.Join(diskBuses, ", "))
+	}
+	if cache != "" && !stringInSlice(cache, diskCaches) {
+		return fmt.Errorf("invalid disk cache '%s', valid values are: %s", cache, strings.Join(diskCaches, ", "))
+	}
+	if format != "" && !stringInSlice(format, diskFormats) {
+		return fmt.Errorf("invalid disk format '%s', valid values are: %s", format, strings.Join(diskFormats, ", "))
+	}
+	return nil
+}
+
+// guestDiskDev returns the device name the guest kernel assigns to the n-th (0 based) disk on the given bus,
+// the letters continue after z with aa, ab, ...
+func guestDiskDev(bus string, n int) string {
+	prefix := "sd"
+	switch bus {
+	case "virtio":
+		prefix = "vd"
+	case "ide":
+		prefix = "hd"
+	}
+	suffix := ""
+	for n++; n > 0; n = (n - 1) / 26 {
+		suffix = string(rune('a'+(n-1)%26)) + suffix
+	}
+	return "/dev/" + prefix + suffix
+}
+
+// assignGuestDevs sets the guest device name of each data disk, following the boot disk on bootBus.
+func assignGuestDevs(bootBus string, disks []DataDisk) {
+	busCount := map[string]int{guestDevBus(bootBus): 1}
+	for i := range disks {
+		b := guestDevBus(disks[i].Bus)
+		disks[i].Dev = guestDiskDev(disks[i].Bus, busCount[b])
+		busCount[b]++
+	}
+}
+
+// scsi and sata disks share the sdX namespace in the guest.
+func guestDevBus(bus string) string {
+	if bus == "sata" {
+		return "scsi"
+	}
+	return bus
+}
+
+func (d DataDisk) CreateRequest(path string) map[string]string {
+	return map[string]string{
+		"creating_image": "true",
+		"path":           path,
+		"size":           fmt.Sprintf("%d", d.Size),
+		"bus":            d.Bus,
+		"cache":          d.Cache,
+		"format":         d.Format,
+	}
+}
+
+func dataDiskFileName(index int, ts int64, format string) string {
+	ext := "img"
+	if format == "qcow2" {
+		ext = "qcow2"
+	}
+	return fmt.Sprintf("data_disk_%d_%d.%s", index, ts, ext)
+}
+
+// findVMDisk looks up a VM disk by guest device name (vdb), index or image file name.
+func findVMDisk(vm VMResponse, ref string) (VMDisksResponse, error) {
+	for _, d := range vm.Disks {
+		if d.Dev == ref || strings.TrimPrefix(ref, "/dev/") == d.Dev || fmt.Sprintf("%d", d.Index) == ref || filepath.Base(d.Path) == ref {
+			return d, nil
+		}
+	}
+	return VMDisksResponse{}, fmt.Errorf("disk '%s' not found on VM %s", ref, vm.Name)
+}
+
+func requireStopped(vm VMResponse, action string) error {
+	if vm.PowerState != QVSPowerStateStop {
+		return fmt.Errorf("error, VM %s must be stopped to %s, current state: %s", vm.Name, action, vm.PowerState)
+	}
+	return nil
+}
+
+// frozenDiskPaths returns the paths of the disks before a snapshot of a running VM, which the snapshot froze
+// when it moved the VM onto new overlays. A disk still on the same path is written by the VM and can't be copied.
+func frozenDiskPaths(before, after []VMDisksResponse) ([]string, error) {
+	var paths []string
+	for _, b := range before {
+		found := false
+		for _, a := range after {
+			if a.ID != b.ID {
+				continue
+			}
+			found = true
+			if a.Path == b.Path {
+				return nil, fmt.Errorf("disk %s is still in use by the VM after the snapshot, stop the VM to clone it", b.Path)
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("disk %s is gone after the snapshot", b.Path)
+		}
+		paths = append(paths, b.Path)
+	}
+	return paths, nil
+}
+
+// vmFolder returns the folder in disksDir named after a VM, which holds the disks qvscli creates for it.
+func vmFolder(disksDir, name string) (string, error) {
+	dir := filepath.Join(disksDir, name)
+	if name == "" || filepath.Dir(dir) != filepath.Clean(disksDir) {
+		return "", fmt.Errorf("VM name '%s' does not resolve to a folder in %s", name, disksDir)
+	}
+	return dir, nil
+}
+
+// diskTopFolder returns the folder directly in disksDir that contains the disk at path.
+func diskTopFolder(disksDir, path string) (string, bool) {
+	rel, err := filepath.Rel(filepath.Clean(disksDir), filepath.Clean(path))
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return "", false
+	}
+	toks := strings.SplitN(rel, string(filepath.Separator), 2)
+	if len(toks) < 2 {
+		return "", false
+	}
+	return filepath.Join(disksDir, toks[0]), true
+}
+
+// vmDiskFolders returns the folders in disksDir owned by vm: its own folder and the folders of its disks,
+// unless another VM has disks in them. The paths that are not owned are returned in kept.
+func vmDiskFolders(disksDir string, vm VMResponse, others []VMResponse) ([]string, []string, error) {
+	own, err := vmFolder(disksDir, vm.Name)
+	if err != nil {
+		return nil, nil, err
+	}
+	shared := make(map[string]bool)
+	for _, o := range others {
+		if o.UUID == vm.UUID {
+			continue
+		}
+		for _, d := range o.Disks {
+			if dir, ok := diskTopFolder(disksDir, d.Path); ok {
+				shared[dir] = true
+			}
+		}
+	}
+	var folders, kept []string
+	if shared[own] {
+		kept = append(kept, own)
+	} else {
+		folders = append(folders, own)
+	}
+	for _, d := range vm.Disks {
+		dir, ok := diskTopFolder(disksDir, d.Path)
+		if !ok || shared[dir] {
+			kept = append(kept, d.Path)
+			continue
+		}
+		if dir != own && !stringInSlice(dir, folders) {
+			folders = append(folders, dir)
+		}
+	}
+	return folders, kept, nil
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseKeyValues(t *testing.T) {
+	allowed := []string{"size", "bus", "mount"}
+	tests := []struct {
+		spec    string
+		want    map[string]string
+		wantErr bool
+	}{
+		{"size=100G,bus=virtio", map[string]string{"size": "100G", "bus": "virtio"}, false},
+		{" size = 10G , mount=/data,", map[string]string{"size": "10G", "mount": "/data"}, false},
+		{"mount=", map[string]string{"mount": ""}, false},
+		{"", map[string]string{}, false},
+		{"size", nil, true},
+		{"size=10G,color=red", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := parseKeyValues(tt.spec, allowed)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseKeyValues(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseKeyValues(%q) = %v, want %v", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestParseDataDisk(t *testing.T) {
+	tests := []struct {
+		spec    string
+		index   int
+		want    DataDisk
+		wantErr bool
+	}{
+		{"size=100G", 1, DataDisk{Size: 100 << 30, Cache: "none", Format: "qcow2", FS: "ext4", MountPoint: "/mnt/data1", Label: "data1"}, false},
+		{"size=1T,bus=virtio,cache=writeback,format=raw,fs=xfs,mount=/srv", 2,
+			DataDisk{Size: 1 << 40, Bus: "virtio", Cache: "writeback", Format: "raw", FS: "xfs", MountPoint: "/srv", Label: "data2"}, false},
+		{"size=10G,mount=none", 1, DataDisk{Size: 10 << 30, Cache: "none", Format: "qcow2", FS: "ext4", MountPoint: "none", Label: "data1"}, false},
+		{"bus=virtio", 1, DataDisk{}, true},
+		{"size=lots", 1, DataDisk{}, true},
+		{"size=10G,bus=floppy", 1, DataDisk{}, true},
+		{"size=10G,format=vmdk", 1, DataDisk{}, true},
+		{"size=10G,mount=data", 1, DataDisk{}, true},
+	}
+	for _, tt := range tests {
+		got, err := parseDataDisk(tt.spec, tt.index)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseDataDisk(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && got != tt.want {
+			t.Errorf("parseDataDisk(%q) = %+v, want %+v", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestGuestDiskDev(t *testing.T) {
+	tests := []struct {
+		bus  string
+		n    int
+		want string
+	}{
+		{"virtio", 0, "/dev/vda"},
+		{"virtio", 2, "/dev/vdc"},
+		{"virtio", 25, "/dev/vdz"},
+		{"virtio", 26, "/dev/vdaa"},
+		{"virtio", 27, "/dev/vdab"},
+		{"virtio", 52, "/dev/vdba"},
+		{"virtio", 701, "/dev/vdzz"},
+		{"virtio", 702, "/dev/vdaaa"},
+		{"ide", 1, "/dev/hdb"},
+		{"sata", 1, "/dev/sdb"},
+		{"", 0, "/dev/sda"},
+	}
+	for _, tt := range tests {
+		if got := guestDiskDev(tt.bus, tt.n); got != tt.want {
+			t.Errorf("guestDiskDev(%q, %d) = %q, want %q", tt.bus, tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestVMDiskFolders(t *testing.T) {
+	disks := func(paths ...string) []VMDisksResponse {
+		var d []VMDisksResponse
+		for _, p := range paths {
+			d = append(d, VMDisksResponse{Path: p})
+		}
+		return d
+	}
+	tests := []struct {
+		name        string
+		vm          VMResponse
+		others      []VMResponse
+		wantFolders []string
+		wantKept    []string
+		wantErr     bool
+	}{
+		{"own folder", VMResponse{UUID: "a", Name: "web", Disks: disks("/disks/web/boot.qcow2", "/disks/web/data.qcow2")}, nil,
+			[]string{"/disks/web"}, nil, false},
+		{"old folder after rename", VMResponse{UUID: "a", Name: "web2", Disks: disks("/disks/web/boot.qcow2")}, nil,
+			[]string{"/disks/web2", "/disks/web"}, nil, false},
+		{"disk outside disks dir", VMResponse{UUID: "a", Name: "web", Disks: disks("/Public/boot.img", "/disks/boot.img")}, nil,
+			[]string{"/disks/web"}, []string{"/Public/boot.img", "/disks/boot.img"}, false},
+		{"folder shared with another VM", VMResponse{UUID: "a", Name: "web", Disks: disks("/disks/db/shared.qcow2")},
+			[]VMResponse{{UUID: "b", Name: "db", Disks: disks("/disks/db/boot.qcow2")}},
+			[]string{"/disks/web"}, []string{"/disks/db/shared.qcow2"}, false},
+		{"own folder used by another VM", VMResponse{UUID: "a", Name: "web"},
+			[]VMResponse{{UUID: "b", Name: "db", Disks: disks("/disks/web/boot.qcow2")}},
+			nil, []string{"/disks/web"}, false},
+		{"listed itself", VMResponse{UUID: "a", Name: "web", Disks: disks("/disks/web/boot.qcow2")},
+			[]VMResponse{{UUID: "a", Name: "web", Disks: disks("/disks/web/boot.qcow2")}},
+			[]string{"/disks/web"}, nil, false},
+		{"parent dir name", VMResponse{UUID: "a", Name: ".."}, nil, nil, nil, true},
+		{"nested name", VMResponse{UUID: "a", Name: "web/boot"}, nil, nil, nil, true},
+		{"empty name", VMResponse{UUID: "a"}, nil, nil, nil, true},
+	}
+	for _, tt := range tests {
+		folders, kept, err := vmDiskFolders("/disks", tt.vm, tt.others)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(folders, tt.wantFolders) || !reflect.DeepEqual(kept, tt.wantKept) {
+			t.Errorf("%s: vmDiskFolders = %v, %v, want %v, %v", tt.name, folders, kept, tt.wantFolders, tt.wantKept)
+		}
+	}
+}
+
+func TestFrozenDiskPaths(t *testing.T) {
+	before := []VMDisksResponse{{ID: 1, Path: "/vm/boot.img"}, {ID: 2, Path: "/vm/data.img"}}
+	tests := []struct {
+		name    string
+		after   []VMDisksResponse
+		want    []string
+		wantErr bool
+	}{
+		{"pivoted", []VMDisksResponse{{ID: 2, Path: "/vm/data.snap1"}, {ID: 1, Path: "/vm/boot.snap1"}}, []string{"/vm/boot.img", "/vm/data.img"}, false},
+		{"disk not pivoted", []VMDisksResponse{{ID: 1, Path: "/vm/boot.snap1"}, {ID: 2, Path: "/vm/data.img"}}, nil, true},
+		{"disk gone", []VMDisksResponse{{ID: 1, Path: "/vm/boot.snap1"}}, nil, true},
+	}
+	for _, tt := range tests {
+		got, err := frozenDiskPaths(before, tt.after)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
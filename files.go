@@ -6,15 +6,23 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"log"
 	"mime/multipart"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 )
 
 func (c *QVSClient) fsReq(function string, query string, form url.Values) (*http.Response, error) {
+	return c.fsReqHeader(function, query, form, nil)
+}
+
+// fsReqHeader is fsReq with additional request headers, a partial content response is accepted too.
+func (c *QVSClient) fsReqHeader(function string, query string, form url.Values, header http.Header) (*http.Response, error) {
 	reqURL := fmt.Sprintf("%s%s?func=%s&sid=%s%s", c.QtsURL, QTSFileStation, function, c.SessionID, query)
 
 	var req *http.Request
@@ -25,6 +33,9 @@ func (c *QVSClient) fsReq(function string, query string, form url.Values) (*http
 	}
 
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=UTF-8")
+	for k, v := range header {
+		req.Header[k] = v
+	}
 	c.reqDebug(req)
 
 	client := &http.Client{
@@ -37,7 +48,7 @@ func (c *QVSClient) fsReq(function string, query string, form url.Values) (*http
 		return nil, err
 	}
 
-	if resp.StatusCode != http.StatusOK {
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
 		return nil, fmt.Errorf("error making request, HTTP status code: %d", resp.StatusCode)
 	}
 
@@ -86,6 +97,113 @@ func (c *QVSClient) CreateDir(destDir string) error {
 	return nil
 }
 
+// FileStation status of a successful request and of a request on a file that already exists.
+const (
+	fsStatusSuccess   = 1
+	fsStatusFileExist = 2
+)
+
+// fsStatus makes a FileStation request and returns the status of the response.
+func (c *QVSClient) fsStatus(function string, form url.Values) (int, error) {
+	resp, err := c.fsReq(function, "", form)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	var status struct {
+		Status int `json:"status"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return 0, fmt.Errorf("error reading %s response: %v", function, err)
+	}
+	return status.Status, nil
+}
+
+// CreateDirExclusive creates destDir and returns an error satisfying os.IsExist if it already exists.
+// The NAS checks and creates the folder in one step, so it can be used as a lock between clients.
+func (c *QVSClient) CreateDirExclusive(destDir string) error {
+	form := url.Values{}
+	form.Add("dest_path", filepath.Dir(destDir))
+	form.Add("dest_folder", filepath.Base(destDir))
+
+	status, err := c.fsStatus("createdir", form)
+	if err != nil {
+		return err
+	}
+	switch status {
+	case fsStatusSuccess:
+		return nil
+	case fsStatusFileExist:
+		return &os.PathError{Op: "createdir", Path: destDir, Err: os.ErrExist}
+	}
+	return fmt.Errorf("error creating directory %s, status: %d", destDir, status)
+}
+
+// Lock takes a lock shared by all clients of the NAS by creating lockDir. The lock folder holds a folder named after
+// the Unix time it was taken at, a lock held for longer than stale is taken over from a client that did not release it.
+// Returns the function that releases the lock.
+func (c *QVSClient) Lock(lockDir string, stale, timeout time.Duration) (func(), error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		err := c.CreateDirExclusive(lockDir)
+		if err == nil {
+			if err := c.CreateDir(filepath.Join(lockDir, fmt.Sprintf("%d", time.Now().Unix()))); err != nil {
+				c.DeleteFile(lockDir)
+				return nil, err
+			}
+			return func() {
+				if err := c.DeleteFile(lockDir); err != nil {
+					log.Printf("WARN: failed to release lock %s: %v", lockDir, err)
+				}
+			}, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+
+		files, err := c.ListDir(lockDir)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			taken, err := strconv.ParseInt(f.Filename, 10, 64)
+			if err != nil || time.Since(time.Unix(taken, 0)) < stale {
+				continue
+			}
+			// Only one of the clients waiting for the stale lock moves it out of the way.
+			staleDir := fmt.Sprintf("%s.stale-%d", filepath.Base(lockDir), time.Now().UnixNano())
+			form := url.Values{}
+			form.Add("path", filepath.Dir(lockDir))
+			form.Add("source_name", filepath.Base(lockDir))
+			form.Add("dest_name", staleDir)
+			if status, err := c.fsStatus("rename", form); err == nil && status == fsStatusSuccess {
+				log.Printf("WARN: Took over lock %s, taken at %s", lockDir, time.Unix(taken, 0).UTC().Format(time.RFC3339))
+				c.DeleteFile(filepath.Join(filepath.Dir(lockDir), staleDir))
+			}
+			break
+		}
+
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("timed out after %s waiting for lock %s, delete it if no other qvscli is running", timeout, lockDir)
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+func (c *QVSClient) EnsureDir(destDir string) error {
+	files, err := c.ListDir(filepath.Dir(destDir))
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if f.Filename == filepath.Base(destDir) && f.IsFolder == 1 {
+			return nil
+		}
+	}
+	log.Printf("INFO: Creating directory on NAS: %s", destDir)
+	return c.CreateDir(destDir)
+}
+
 func (c *QVSClient) CopyFile(srcPath string, destPath string) error {
 	srcFile := filepath.Base(srcPath)
 	srcDir := filepath.Dir(srcPath)
@@ -105,6 +223,42 @@ func (c *QVSClient) CopyFile(srcPath string, destPath string) error {
 	return nil
 }
 
+func (c *QVSClient) MoveFile(srcPath string, destDir string) error {
+	form := url.Values{}
+	form.Add("source_total", "1")
+	form.Add("mode", "0")
+	form.Add("source_file", filepath.Base(srcPath))
+	form.Add("source_path", filepath.Dir(srcPath))
+	form.Add("dest_path", destDir)
+
+	_, err := c.fsReq("move", "", form)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// CopyFileAs copies srcPath to destPath under a new file name. The copy is made in a temporary folder next to
+// destPath, so it never collides with a file of the same name as the source in the destination folder.
+func (c *QVSClient) CopyFileAs(srcPath string, destPath string) error {
+	tmpDir := filepath.Join(filepath.Dir(destPath), fmt.Sprintf(".%s.tmp", filepath.Base(destPath)))
+	if err := c.CreateDir(tmpDir); err != nil {
+		return err
+	}
+	defer func() {
+		if err := c.DeleteFile(tmpDir); err != nil {
+			log.Printf("WARN: failed to delete temporary folder %s: %v", tmpDir, err)
+		}
+	}()
+	if err := c.CopyFile(srcPath, filepath.Join(tmpDir, filepath.Base(srcPath))); err != nil {
+		return err
+	}
+	if err := c.RenameFile(tmpDir, filepath.Base(srcPath), filepath.Base(destPath)); err != nil {
+		return err
+	}
+	return c.MoveFile(filepath.Join(tmpDir, filepath.Base(destPath)), filepath.Dir(destPath))
+}
+
 func (c *QVSClient) RenameFile(srcPath, srcName, destName string) error {
 	form := url.Values{}
 	form.Add("path", srcPath)
@@ -136,6 +290,21 @@ func (c *QVSClient) DeleteFile(srcPath string) error {
 	return nil
 }
 
+// ReadFileHead reads the first n bytes of a file. Only that range is requested, and the body is cut off
+// after n bytes in case the NAS sends the whole file anyway.
+func (c *QVSClient) ReadFileHead(srcPath string, n int64) ([]byte, error) {
+	query := fmt.Sprintf("&isfolder=0&compress=0&source_total=1&source_path=%s&source_file=%s", url.QueryEscape(filepath.Dir(srcPath)), url.QueryEscape(filepath.Base(srcPath)))
+
+	header := http.Header{"Range": {fmt.Sprintf("bytes=0-%d", n-1)}}
+	resp, err := c.fsReqHeader("download", query, nil, header)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	return ioutil.ReadAll(io.LimitReader(resp.Body, n))
+}
+
 func (c *QVSClient) UploadFile(srcFile *os.File, destPath string) error {
 	destDir := filepath.Dir(destPath)
 	qtsPath := strings.Replace(destPath, "/", "-", -1)
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestReadFileHead(t *testing.T) {
+	content := strings.Repeat("0123456789", 100)
+	tests := []struct {
+		name       string
+		honorRange bool
+		status     int
+		n          int64
+		want       string
+		wantErr    bool
+		wantRange  string
+	}{
+		{name: "ranged", honorRange: true, status: http.StatusPartialContent, n: 16, want: content[:16], wantRange: "bytes=0-15"},
+		{name: "range ignored", status: http.StatusOK, n: 16, want: content[:16], wantRange: "bytes=0-15"},
+		{name: "short file", status: http.StatusOK, n: 2048, want: content, wantRange: "bytes=0-2047"},
+		{name: "error status", status: http.StatusInternalServerError, n: 16, wantErr: true, wantRange: "bytes=0-15"},
+	}
+	for _, tt := range tests {
+		var gotRange, gotPath, gotFile string
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			gotRange = r.Header.Get("Range")
+			gotPath = r.URL.Query().Get("source_path")
+			gotFile = r.URL.Query().Get("source_file")
+			body := content
+			if tt.honorRange && tt.n < int64(len(content)) {
+				body = content[:tt.n]
+			}
+			w.WriteHeader(tt.status)
+			fmt.Fprint(w, body)
+		}))
+		jar, _ := cookiejar.New(nil)
+		c := &QVSClient{QtsURL: ts.URL, CookieJar: jar}
+		got, err := c.ReadFileHead("/VMs/web/boot_disk.qcow2", tt.n)
+		ts.Close()
+		if gotRange != tt.wantRange || gotPath != "/VMs/web" || gotFile != "boot_disk.qcow2" {
+			t.Errorf("%s: request Range %q, source %s/%s", tt.name, gotRange, gotPath, gotFile)
+		}
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if string(got) != tt.want {
+			t.Errorf("%s: got %d bytes, want %d", tt.name, len(got), len(tt.want))
+		}
+	}
+}
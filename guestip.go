@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// VMAgentInterfaces returns the guest network interfaces reported by the guest agent of a running VM.
+func (c *QVSClient) VMAgentInterfaces(id string) ([]VMAgentInterface, error) {
+	resp, err := c.qvsReq("GET", fmt.Sprintf(QVSVMAgentInterfaces, id), "")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var ifaces VMAgentInterfacesResponse
+	if err := json.NewDecoder(resp.Body).Decode(&ifaces); err != nil {
+		return nil, err
+	}
+	return ifaces.Data, nil
+}
+
+// VMIPs returns the IP addresses of a running VM. Addresses reported by the guest agent are used when
+// available, otherwise the addresses are looked up by MAC in neighbors, see NetMgrNeighbors.
+func (c *QVSClient) VMIPs(vm VMResponse, neighbors []NetMgrNeighbor) []string {
+	if vm.PowerState != QVSPowerStateRunning {
+		return nil
+	}
+	var ips []string
+	if ifaces, err := c.VMAgentInterfaces(fmt.Sprintf("%d", vm.ID)); err == nil {
+		for _, a := range vm.Adapters {
+			for _, iface := range ifaces {
+				if !strings.EqualFold(iface.MAC, a.MAC) {
+					continue
+				}
+				for _, addr := range iface.IPAddresses {
+					if usableIP(addr.IP) && !stringInSlice(addr.IP, ips) {
+						ips = append(ips, addr.IP)
+					}
+				}
+			}
+		}
+	}
+	if len(ips) > 0 {
+		return ips
+	}
+	for _, a := range vm.Adapters {
+		for _, n := range neighbors {
+			if strings.EqualFold(n.MAC, a.MAC) && usableIP(n.IP) && !stringInSlice(n.IP, ips) {
+				ips = append(ips, n.IP)
+			}
+		}
+	}
+	return ips
+}
+
+// VMWaitIP polls until an IP address of the VM is known.
+func (c *QVSClient) VMWaitIP(idOrName string, timeout time.Duration) ([]string, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		vm, err := c.VMGet(idOrName)
+		if err != nil {
+			return nil, err
+		}
+		neighbors, _ := c.NetMgrNeighbors()
+		if ips := c.VMIPs(vm, neighbors); len(ips) > 0 {
+			return ips, nil
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("timed out after %s waiting for an IP address of VM %s", timeout, vm.Name)
+		}
+		time.Sleep(5 * time.Second)
+	}
+}
+
+// usableIP filters out loopback and link-local addresses the guest reports for every interface.
+func usableIP(s string) bool {
+	ip := net.ParseIP(s)
+	return ip != nil && !ip.IsLoopback() && !ip.IsLinkLocalUnicast() && !ip.IsUnspecified()
+}
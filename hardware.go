@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var firmwareTypes = []string{"bios", "uefi"}
+var cpuModes = []string{"host-passthrough", "host-model"}
+
+// newHardwareRequest validates firmware, machine and CPU options, empty values are left at the QVS defaults.
+func newHardwareRequest(firmware string, secureBoot bool, machine string, cpuModel string, sockets int, threads int, cores int) (QVSHardwareRequest, error) {
+	var hw QVSHardwareRequest
+	if firmware != "" && !stringInSlice(firmware, firmwareTypes) {
+		return hw, fmt.Errorf("invalid firmware '%s', valid values are: %s", firmware, strings.Join(firmwareTypes, ", "))
+	}
+	hw.Firmware = firmware
+	if secureBoot {
+		if firmware != "uefi" {
+			return hw, fmt.Errorf("secure boot requires uefi firmware")
+		}
+		hw.SecureBoot = &secureBoot
+	}
+	hw.Machine = machine
+
+	switch {
+	case cpuModel == "":
+	case stringInSlice(cpuModel, cpuModes):
+		hw.CPUMode = cpuModel
+	default:
+		hw.CPUMode = "custom"
+		hw.CPUModel = cpuModel
+	}
+
+	if sockets < 0 || threads < 0 {
+		return hw, fmt.Errorf("invalid CPU topology, sockets and threads must be positive")
+	}
+	if sockets > 0 || threads > 0 {
+		s, t := sockets, threads
+		if s == 0 {
+			s = 1
+		}
+		if t == 0 {
+			t = 1
+		}
+		if cores > 0 && cores%(s*t) != 0 {
+			return hw, fmt.Errorf("invalid CPU topology, %d cores can not be split into %d sockets with %d threads", cores, s, t)
+		}
+		hw.Sockets = s
+		hw.Threads = t
+	}
+	return hw, nil
+}
+
+// parseBootOrder parses a boot order like 'disk,cdrom' or 'cdrom0,disk1,disk0' into device keys
+// (disk0, cdrom0, ...) in boot order.
+func parseBootOrder(spec string, nDisks int, nCDROMs int) ([]string, error) {
+	var order []string
+	for _, dev := range strings.Split(spec, ",") {
+		dev = strings.TrimSpace(dev)
+		if dev == "" {
+			continue
+		}
+		kind := strings.TrimRight(dev, "0123456789")
+		n := 0
+		if kind != dev {
+			n, _ = strconv.Atoi(dev[len(kind):])
+		}
+		max := 0
+		switch kind {
+		case "disk":
+			max = nDisks
+		case "cdrom":
+			max = nCDROMs
+		default:
+			return nil, fmt.Errorf("invalid boot device '%s', use disk[N] or cdrom[N]", dev)
+		}
+		if n >= max {
+			return nil, fmt.Errorf("invalid boot device '%s', the VM has %d %s devices", dev, max, kind)
+		}
+		key := fmt.Sprintf("%s%d", kind, n)
+		if stringInSlice(key, order) {
+			return nil, fmt.Errorf("boot device '%s' listed more than once", dev)
+		}
+		order = append(order, key)
+	}
+	return order, nil
+}
+
+// bootOrderOf returns the 1 based boot order of a device key, 0 if it is not bootable.
+func bootOrderOf(order []string, key string) int {
+	for i, k := range order {
+		if k == key {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// applyBootOrder sets the boot_order of disk and cdrom create requests.
+func applyBootOrder(order []string, disks []map[string]string, cdroms []map[string]string) {
+	for i := range disks {
+		disks[i]["boot_order"] = fmt.Sprintf("%d", bootOrderOf(order, fmt.Sprintf("disk%d", i)))
+	}
+	for i := range cdroms {
+		cdroms[i]["boot_order"] = fmt.Sprintf("%d", bootOrderOf(order, fmt.Sprintf("cdrom%d", i)))
+	}
+}
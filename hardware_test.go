@@ -0,0 +1,90 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseBootOrder(t *testing.T) {
+	tests := []struct {
+		spec    string
+		disks   int
+		cdroms  int
+		want    []string
+		wantErr bool
+	}{
+		{"disk,cdrom", 1, 1, []string{"disk0", "cdrom0"}, false},
+		{"cdrom0, disk1 ,disk0", 2, 1, []string{"cdrom0", "disk1", "disk0"}, false},
+		{"disk1", 2, 0, []string{"disk1"}, false},
+		{"", 1, 1, nil, false},
+		{"cdrom", 1, 0, nil, true},
+		{"disk2", 2, 1, nil, true},
+		{"disk0,disk", 1, 1, nil, true},
+		{"net", 1, 1, nil, true},
+		{"usb0", 1, 1, nil, true},
+	}
+	for _, tt := range tests {
+		got, err := parseBootOrder(tt.spec, tt.disks, tt.cdroms)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseBootOrder(%q, %d, %d) error = %v, wantErr %v", tt.spec, tt.disks, tt.cdroms, err, tt.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseBootOrder(%q, %d, %d) = %v, want %v", tt.spec, tt.disks, tt.cdroms, got, tt.want)
+		}
+	}
+}
+
+func TestApplyBootOrder(t *testing.T) {
+	disks := []map[string]string{{}, {}}
+	cdroms := []map[string]string{{}}
+	applyBootOrder([]string{"cdrom0", "disk1"}, disks, cdroms)
+	want := []string{disks[0]["boot_order"], disks[1]["boot_order"], cdroms[0]["boot_order"]}
+	if !reflect.DeepEqual(want, []string{"0", "2", "1"}) {
+		t.Errorf("applyBootOrder set disk0, disk1, cdrom0 to %v, want [0 2 1]", want)
+	}
+}
+
+func TestNewHardwareRequest(t *testing.T) {
+	tests := []struct {
+		name       string
+		firmware   string
+		secureBoot bool
+		cpuModel   string
+		sockets    int
+		threads    int
+		cores      int
+		wantMode   string
+		wantModel  string
+		wantTopo   [2]int
+		wantErr    bool
+	}{
+		{name: "defaults"},
+		{name: "passthrough", cpuModel: "host-passthrough", wantMode: "host-passthrough"},
+		{name: "custom model", cpuModel: "Haswell", wantMode: "custom", wantModel: "Haswell"},
+		{name: "topology", sockets: 2, threads: 2, cores: 8, wantTopo: [2]int{2, 2}},
+		{name: "sockets only", sockets: 2, cores: 4, wantTopo: [2]int{2, 1}},
+		{name: "uneven topology", sockets: 3, cores: 4, wantErr: true},
+		{name: "negative threads", threads: -1, wantErr: true},
+		{name: "bad firmware", firmware: "coreboot", wantErr: true},
+		{name: "secure boot on bios", firmware: "bios", secureBoot: true, wantErr: true},
+		{name: "secure boot on uefi", firmware: "uefi", secureBoot: true},
+		{name: "secure boot without firmware", secureBoot: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		hw, err := newHardwareRequest(tt.firmware, tt.secureBoot, "", tt.cpuModel, tt.sockets, tt.threads, tt.cores)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if tt.wantErr {
+			continue
+		}
+		if hw.CPUMode != tt.wantMode || hw.CPUModel != tt.wantModel || [2]int{hw.Sockets, hw.Threads} != tt.wantTopo {
+			t.Errorf("%s: got mode %q model %q topology %d/%d", tt.name, hw.CPUMode, hw.CPUModel, hw.Sockets, hw.Threads)
+		}
+		if (hw.SecureBoot != nil) != tt.secureBoot {
+			t.Errorf("%s: secure boot = %v, want %t", tt.name, hw.SecureBoot, tt.secureBoot)
+		}
+	}
+}
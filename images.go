@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const imageMetadataSuffix = ".json"
+
+var imageExtensions = []string{".img", ".qcow2", ".raw"}
+
+// isImageFile reports whether a file in the images dir is a disk image, by extension.
+func isImageFile(name string) bool {
+	return stringInSlice(strings.ToLower(filepath.Ext(name)), imageExtensions)
+}
+
+// ImageMetadataGet reads the metadata sidecar of an image, returns nil if the image has none.
+func (c *QVSClient) ImageMetadataGet(imagePath string) (*ImageMetadata, error) {
+	metaPath := imagePath + imageMetadataSuffix
+	files, err := c.ListDir(filepath.Dir(metaPath))
+	if err != nil {
+		return nil, err
+	}
+	found := false
+	for _, f := range files {
+		if f.Filename == filepath.Base(metaPath) {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil, nil
+	}
+
+	data, err := c.ReadFileHead(metaPath, 1<<20)
+	if err != nil {
+		return nil, err
+	}
+	var meta ImageMetadata
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, err
+	}
+	return &meta, nil
+}
+
+func (c *QVSClient) ImageMetadataPut(imagePath string, meta ImageMetadata) error {
+	dir, err := ioutil.TempDir("", "qvs-image-meta")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	metaPath := imagePath + imageMetadataSuffix
+	tmpFile := filepath.Join(dir, filepath.Base(metaPath))
+	data, _ := json.MarshalIndent(&meta, "", "  ")
+	if err := ioutil.WriteFile(tmpFile, data, 0644); err != nil {
+		return err
+	}
+	f, err := os.Open(tmpFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return c.UploadFile(f, metaPath)
+}
@@ -0,0 +1,24 @@
+package main
+
+import "testing"
+
+func TestIsImageFile(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"xenial.img", true},
+		{"web.qcow2", true},
+		{"WIN10.QCOW2", true},
+		{"disk.raw", true},
+		{"web.qcow2.json", false},
+		{"notes.txt", false},
+		{"img", false},
+		{"ubuntu.iso", false},
+	}
+	for _, tt := range tests {
+		if got := isImageFile(tt.name); got != tt.want {
+			t.Errorf("isImageFile(%q) = %t, want %t", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const ipamFileName = "qvscli_ipam.json"
+
+// The IPAM file is locked while it is updated, locks older than ipamLockStale are left over from a failed qvscli.
+const (
+	ipamLockStale   = 2 * time.Minute
+	ipamLockTimeout = 30 * time.Second
+)
+
+// errIPAMUnchanged is returned by IPAMUpdate functions that did not change the IPAM, it is not saved then.
+var errIPAMUnchanged = errors.New("IPAM unchanged")
+
+// IPAMPool is the range of static addresses qvscli hands out on a QVS network.
+type IPAMPool struct {
+	Network    string   `json:"network"`
+	CIDR       string   `json:"cidr"`
+	RangeStart string   `json:"range_start"`
+	RangeEnd   string   `json:"range_end"`
+	Gateway    string   `json:"gateway,omitempty"`
+	DNS        []string `json:"dns,omitempty"`
+	Search     []string `json:"search,omitempty"`
+}
+
+// IPAMLease is an address allocated to a VM NIC. The VM UUID is set once the VM is created.
+type IPAMLease struct {
+	Network string    `json:"network"`
+	IP      string    `json:"ip"`
+	MAC     string    `json:"mac"`
+	VMName  string    `json:"vm_name"`
+	VMUUID  string    `json:"vm_uuid,omitempty"`
+	Created time.Time `json:"created"`
+}
+
+// IPAM holds the pools and leases, kept in a JSON file on the NAS so it is shared by everyone creating VMs.
+type IPAM struct {
+	path   string
+	Pools  []IPAMPool  `json:"pools"`
+	Leases []IPAMLease `json:"leases"`
+}
+
+// IPAMLoad reads the IPAM file on the NAS, a missing file is an empty IPAM.
+// IPAMExists reports whether the IPAM file has been created, which happens when the first pool is set.
+func (c *QVSClient) IPAMExists(path string) (bool, error) {
+	files, err := c.ListDir(filepath.Dir(path))
+	if err != nil {
+		return false, err
+	}
+	for _, f := range files {
+		if f.Filename == filepath.Base(path) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (c *QVSClient) IPAMLoad(path string) (*IPAM, error) {
+	ipam := &IPAM{path: path}
+	found, err := c.IPAMExists(path)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return ipam, nil
+	}
+	data, err := c.ReadFileHead(path, 1<<20)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, ipam); err != nil {
+		return nil, fmt.Errorf("error reading IPAM file %s: %v", path, err)
+	}
+	return ipam, nil
+}
+
+func (c *QVSClient) IPAMSave(ipam *IPAM) error {
+	dir, err := ioutil.TempDir("", "qvs-ipam")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	tmpFile := filepath.Join(dir, filepath.Base(ipam.path))
+	data, _ := json.MarshalIndent(ipam, "", "  ")
+	if err := ioutil.WriteFile(tmpFile, data, 0644); err != nil {
+		return err
+	}
+	f, err := os.Open(tmpFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return c.UploadFile(f, ipam.path)
+}
+
+// IPAMUpdate loads the IPAM file, applies update and saves it, while holding the lock of the file.
+func (c *QVSClient) IPAMUpdate(path string, update func(*IPAM) error) error {
+	unlock, err := c.Lock(path+".lock", ipamLockStale, ipamLockTimeout)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	ipam, err := c.IPAMLoad(path)
+	if err != nil {
+		return err
+	}
+	if err := update(ipam); err != nil {
+		if err == errIPAMUnchanged {
+			return nil
+		}
+		return err
+	}
+	return c.IPAMSave(ipam)
+}
+
+// IPAMAllocate leases a free address of the pool of the NIC network and returns the NIC with the address,
+// and the gateway, DNS servers and search domains of the pool where the NIC does not set them.
+func (c *QVSClient) IPAMAllocate(path string, n NIC, vmName string) (NIC, error) {
+	// Addresses answering on the network are skipped even when they are not leased.
+	inUse := make(map[string]bool)
+	neighbors, _ := c.NetMgrNeighbors()
+	for _, nb := range neighbors {
+		inUse[nb.IP] = true
+	}
+
+	var pool IPAMPool
+	var lease IPAMLease
+	err := c.IPAMUpdate(path, func(ipam *IPAM) error {
+		var err error
+		pool, lease, err = ipam.Allocate(n.Network, vmName, n.MAC, inUse)
+		return err
+	})
+	if err != nil {
+		return n, err
+	}
+	_, subnet, _ := net.ParseCIDR(pool.CIDR)
+	ones, _ := subnet.Mask.Size()
+	n.IP = fmt.Sprintf("%s/%d", lease.IP, ones)
+	if n.Gateway == "" {
+		n.Gateway = pool.Gateway
+	}
+	if len(n.DNS) == 0 {
+		n.DNS = pool.DNS
+	}
+	if len(n.Search) == 0 {
+		n.Search = pool.Search
+	}
+	log.Printf("INFO: Allocated IP %s on network %s for %s", n.IP, n.Network, n.MAC)
+	return n, nil
+}
+
+// IPAMBind records the UUID of a newly created VM in the leases of its MACs.
+func (c *QVSClient) IPAMBind(path string, macs []string, uuid string) error {
+	return c.IPAMUpdate(path, func(ipam *IPAM) error {
+		for i, l := range ipam.Leases {
+			for _, mac := range macs {
+				if strings.EqualFold(l.MAC, mac) {
+					ipam.Leases[i].VMUUID = uuid
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// IPAMRelease removes the leases of a VM, matched by UUID or MAC. Without an IPAM file there is nothing to lock.
+func (c *QVSClient) IPAMRelease(path string, uuid string, macs []string) ([]IPAMLease, error) {
+	if found, err := c.IPAMExists(path); err != nil || !found {
+		return nil, err
+	}
+	var released []IPAMLease
+	err := c.IPAMUpdate(path, func(ipam *IPAM) error {
+		released = ipam.Release(uuid, macs)
+		if len(released) == 0 {
+			return errIPAMUnchanged
+		}
+		return nil
+	})
+	return released, err
+}
+
+func (ipam *IPAM) Pool(network string) *IPAMPool {
+	for i := range ipam.Pools {
+		if ipam.Pools[i].Network == network {
+			return &ipam.Pools[i]
+		}
+	}
+	return nil
+}
+
+// SetPool adds or replaces the pool of a network, leases outside of the new range are kept.
+func (ipam *IPAM) SetPool(p IPAMPool) {
+	if old := ipam.Pool(p.Network); old != nil {
+		*old = p
+		return
+	}
+	ipam.Pools = append(ipam.Pools, p)
+}
+
+func (ipam *IPAM) DeletePool(network string) error {
+	for _, l := range ipam.Leases {
+		if l.Network == network {
+			return fmt.Errorf("network %s still has leases, delete the VMs first. IP %s is leased to %s", network, l.IP, l.VMName)
+		}
+	}
+	for i, p := range ipam.Pools {
+		if p.Network == network {
+			ipam.Pools = append(ipam.Pools[:i], ipam.Pools[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("no IP pool for network %s", network)
+}
+
+// Release removes the leases of a VM, matched by UUID or MAC, and returns them.
+func (ipam *IPAM) Release(uuid string, macs []string) []IPAMLease {
+	var kept, released []IPAMLease
+	for _, l := range ipam.Leases {
+		match := uuid != "" && l.VMUUID == uuid
+		for _, mac := range macs {
+			match = match || strings.EqualFold(l.MAC, mac)
+		}
+		if match {
+			released = append(released, l)
+		} else {
+			kept = append(kept, l)
+		}
+	}
+	if len(released) > 0 {
+		ipam.Leases = kept
+	}
+	return released
+}
+
+func (ipam *IPAM) Lease(ip string) *IPAMLease {
+	for i := range ipam.Leases {
+		if ipam.Leases[i].IP == ip {
+			return &ipam.Leases[i]
+		}
+	}
+	return nil
+}
+
+// Allocate leases the first address of the pool of network that is not leased, in inUse or the gateway.
+func (ipam *IPAM) Allocate(network, vmName, mac string, inUse map[string]bool) (IPAMPool, IPAMLease, error) {
+	pool := ipam.Pool(network)
+	if pool == nil {
+		return IPAMPool{}, IPAMLease{}, fmt.Errorf("no IP pool for network %s, create one with 'qvscli net pool set %s'", network, network)
+	}
+	start, end, err := pool.bounds()
+	if err != nil {
+		return *pool, IPAMLease{}, err
+	}
+	for i := start; ; i++ {
+		ip := uint32ToIP(i).String()
+		if ip != pool.Gateway && !inUse[ip] && ipam.Lease(ip) == nil {
+			l := IPAMLease{Network: network, IP: ip, MAC: strings.ToLower(mac), VMName: vmName, Created: time.Now().UTC()}
+			ipam.Leases = append(ipam.Leases, l)
+			return *pool, l, nil
+		}
+		if i == end {
+			break
+		}
+	}
+	return *pool, IPAMLease{}, fmt.Errorf("IP pool of network %s is exhausted, %s - %s", network, pool.RangeStart, pool.RangeEnd)
+}
+
+// bounds returns the first and last address of the range of an IPv4 pool.
+func (p IPAMPool) bounds() (uint32, uint32, error) {
+	_, subnet, err := net.ParseCIDR(p.CIDR)
+	if err != nil || subnet.IP.To4() == nil {
+		return 0, 0, fmt.Errorf("invalid pool CIDR '%s', only IPv4 pools are supported", p.CIDR)
+	}
+	start := net.ParseIP(p.RangeStart).To4()
+	end := net.ParseIP(p.RangeEnd).To4()
+	if start == nil || end == nil {
+		return 0, 0, fmt.Errorf("invalid pool range '%s - %s'", p.RangeStart, p.RangeEnd)
+	}
+	if !subnet.Contains(start) || !subnet.Contains(end) {
+		return 0, 0, fmt.Errorf("pool range %s - %s is not in %s", p.RangeStart, p.RangeEnd, p.CIDR)
+	}
+	s, e := binary.BigEndian.Uint32(start), binary.BigEndian.Uint32(end)
+	if s > e {
+		return 0, 0, fmt.Errorf("pool range start %s is after the end %s", p.RangeStart, p.RangeEnd)
+	}
+	ones, bits := subnet.Mask.Size()
+	if bits-ones > 1 {
+		network := binary.BigEndian.Uint32(subnet.IP.To4())
+		broadcast := network | (1<<uint(bits-ones) - 1)
+		if s == network || e == broadcast {
+			return 0, 0, fmt.Errorf("pool range %s - %s includes the network or broadcast address of %s", p.RangeStart, p.RangeEnd, p.CIDR)
+		}
+	}
+	return s, e, nil
+}
+
+func (p IPAMPool) validate() error {
+	if _, _, err := p.bounds(); err != nil {
+		return err
+	}
+	n := NIC{Network: p.Network, IP: p.CIDR, Gateway: p.Gateway, DNS: p.DNS}
+	return n.validateAddressing()
+}
+
+// parseIPRange parses a range like 10.0.0.100-10.0.0.199.
+func parseIPRange(r string) (string, string, error) {
+	toks := strings.SplitN(r, "-", 2)
+	if len(toks) != 2 {
+		return "", "", fmt.Errorf("invalid range '%s', expected <first IP>-<last IP>", r)
+	}
+	return strings.TrimSpace(toks[0]), strings.TrimSpace(toks[1]), nil
+}
+
+func uint32ToIP(i uint32) net.IP {
+	ip := make(net.IP, 4)
+	binary.BigEndian.PutUint32(ip, i)
+	return ip
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestIPAMPoolBounds(t *testing.T) {
+	tests := []struct {
+		name    string
+		pool    IPAMPool
+		start   string
+		end     string
+		wantErr bool
+	}{
+		{"range", IPAMPool{CIDR: "10.0.0.0/24", RangeStart: "10.0.0.100", RangeEnd: "10.0.0.199"}, "10.0.0.100", "10.0.0.199", false},
+		{"whole subnet", IPAMPool{CIDR: "10.0.0.0/24", RangeStart: "10.0.0.1", RangeEnd: "10.0.0.254"}, "10.0.0.1", "10.0.0.254", false},
+		{"single address", IPAMPool{CIDR: "10.0.0.0/24", RangeStart: "10.0.0.5", RangeEnd: "10.0.0.5"}, "10.0.0.5", "10.0.0.5", false},
+		{"point to point", IPAMPool{CIDR: "10.0.0.0/31", RangeStart: "10.0.0.0", RangeEnd: "10.0.0.1"}, "10.0.0.0", "10.0.0.1", false},
+		{"network address", IPAMPool{CIDR: "10.0.0.0/24", RangeStart: "10.0.0.0", RangeEnd: "10.0.0.10"}, "", "", true},
+		{"broadcast address", IPAMPool{CIDR: "10.0.0.0/24", RangeStart: "10.0.0.200", RangeEnd: "10.0.0.255"}, "", "", true},
+		{"outside subnet", IPAMPool{CIDR: "10.0.0.0/24", RangeStart: "10.0.0.100", RangeEnd: "10.0.1.10"}, "", "", true},
+		{"reversed", IPAMPool{CIDR: "10.0.0.0/24", RangeStart: "10.0.0.20", RangeEnd: "10.0.0.10"}, "", "", true},
+		{"IPv6", IPAMPool{CIDR: "fd00::/64", RangeStart: "fd00::10", RangeEnd: "fd00::20"}, "", "", true},
+		{"invalid address", IPAMPool{CIDR: "10.0.0.0/24", RangeStart: "10.0.0.x", RangeEnd: "10.0.0.20"}, "", "", true},
+	}
+	for _, tt := range tests {
+		s, e, err := tt.pool.bounds()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if tt.wantErr {
+			continue
+		}
+		if got := uint32ToIP(s).String(); got != tt.start {
+			t.Errorf("%s: start = %s, want %s", tt.name, got, tt.start)
+		}
+		if got := uint32ToIP(e).String(); got != tt.end {
+			t.Errorf("%s: end = %s, want %s", tt.name, got, tt.end)
+		}
+	}
+}
+
+func TestIPAMAllocate(t *testing.T) {
+	pool := IPAMPool{Network: "br0", CIDR: "10.0.0.0/24", RangeStart: "10.0.0.1", RangeEnd: "10.0.0.4", Gateway: "10.0.0.1"}
+	tests := []struct {
+		name    string
+		leases  []string
+		inUse   []string
+		network string
+		want    string
+		wantErr bool
+	}{
+		{"skips the gateway", nil, nil, "br0", "10.0.0.2", false},
+		{"skips leases", []string{"10.0.0.2"}, nil, "br0", "10.0.0.3", false},
+		{"skips addresses in use", []string{"10.0.0.2"}, []string{"10.0.0.3"}, "br0", "10.0.0.4", false},
+		{"last address", []string{"10.0.0.2", "10.0.0.3"}, nil, "br0", "10.0.0.4", false},
+		{"exhausted", []string{"10.0.0.2", "10.0.0.3"}, []string{"10.0.0.4"}, "br0", "", true},
+		{"no pool", nil, nil, "br1", "", true},
+	}
+	for _, tt := range tests {
+		ipam := &IPAM{Pools: []IPAMPool{pool}}
+		for _, ip := range tt.leases {
+			ipam.Leases = append(ipam.Leases, IPAMLease{Network: "br0", IP: ip})
+		}
+		inUse := make(map[string]bool)
+		for _, ip := range tt.inUse {
+			inUse[ip] = true
+		}
+		_, lease, err := ipam.Allocate(tt.network, "web", "00:08:9B:AA:BB:CC", inUse)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if tt.wantErr {
+			if len(ipam.Leases) != len(tt.leases) {
+				t.Errorf("%s: failed allocation added a lease", tt.name)
+			}
+			continue
+		}
+		if lease.IP != tt.want || lease.MAC != "00:08:9b:aa:bb:cc" || lease.VMName != "web" {
+			t.Errorf("%s: lease = %+v, want IP %s", tt.name, lease, tt.want)
+		}
+		if ipam.Lease(tt.want) == nil {
+			t.Errorf("%s: lease of %s not recorded", tt.name, tt.want)
+		}
+	}
+}
+
+func TestIPAMAllocateTopOfRange(t *testing.T) {
+	// The loop over the range must stop at the end even when it is the last IPv4 address.
+	ipam := &IPAM{Pools: []IPAMPool{{Network: "br0", CIDR: "255.255.255.254/31", RangeStart: "255.255.255.254", RangeEnd: "255.255.255.255"}}}
+	for _, want := range []string{"255.255.255.254", "255.255.255.255"} {
+		_, l, err := ipam.Allocate("br0", "web", "", nil)
+		if err != nil || l.IP != want {
+			t.Fatalf("Allocate = %s, %v, want %s", l.IP, err, want)
+		}
+	}
+	if _, _, err := ipam.Allocate("br0", "web", "", nil); err == nil {
+		t.Error("Allocate on an exhausted pool: expected an error")
+	}
+}
+
+func TestIPAMRelease(t *testing.T) {
+	ipam := &IPAM{Leases: []IPAMLease{
+		{IP: "10.0.0.2", MAC: "00:08:9b:00:00:01", VMUUID: "uuid-1"},
+		{IP: "10.0.0.3", MAC: "00:08:9b:00:00:02"},
+		{IP: "10.0.0.4", MAC: "00:08:9b:00:00:03", VMUUID: "uuid-2"},
+	}}
+	released := ipam.Release("uuid-1", []string{"00:08:9B:00:00:02"})
+	var ips []string
+	for _, l := range released {
+		ips = append(ips, l.IP)
+	}
+	if !reflect.DeepEqual(ips, []string{"10.0.0.2", "10.0.0.3"}) {
+		t.Errorf("released %v, want [10.0.0.2 10.0.0.3]", ips)
+	}
+	if len(ipam.Leases) != 1 || ipam.Leases[0].IP != "10.0.0.4" {
+		t.Errorf("kept %+v, want only 10.0.0.4", ipam.Leases)
+	}
+	if released := ipam.Release("uuid-3", nil); len(released) != 0 || len(ipam.Leases) != 1 {
+		t.Errorf("release of an unknown VM changed the leases")
+	}
+}
+
+func TestIPAMDeletePool(t *testing.T) {
+	ipam := &IPAM{
+		Pools:  []IPAMPool{{Network: "br0"}, {Network: "br1"}},
+		Leases: []IPAMLease{{Network: "br1", IP: "10.0.1.2"}},
+	}
+	if err := ipam.DeletePool("br1"); err == nil {
+		t.Error("DeletePool of a network with leases: expected an error")
+	}
+	if err := ipam.DeletePool("br2"); err == nil {
+		t.Error("DeletePool of a network without pool: expected an error")
+	}
+	if err := ipam.DeletePool("br0"); err != nil || ipam.Pool("br0") != nil || ipam.Pool("br1") == nil {
+		t.Errorf("DeletePool(br0) = %v, pools %+v", err, ipam.Pools)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// X11 keysyms of named keys accepted by 'vm sendkeys'.
+var keysyms = map[string]uint32{
+	"backspace": 0xff08,
+	"tab":       0xff09,
+	"enter":     0xff0d,
+	"return":    0xff0d,
+	"esc":       0xff1b,
+	"escape":    0xff1b,
+	"home":      0xff50,
+	"left":      0xff51,
+	"up":        0xff52,
+	"right":     0xff53,
+	"down":      0xff54,
+	"pageup":    0xff55,
+	"pagedown":  0xff56,
+	"end":       0xff57,
+	"insert":    0xff63,
+	"delete":    0xffff,
+	"del":       0xffff,
+	"shift":     0xffe1,
+	"ctrl":      0xffe3,
+	"control":   0xffe3,
+	"alt":       0xffe9,
+	"super":     0xffeb,
+	"win":       0xffeb,
+	"space":     0x0020,
+	"minus":     0x002d,
+	"plus":      0x002b,
+	"sysrq":     0xff15,
+}
+
+// Characters that need shift on a US keyboard, the VNC server translates keysyms to scancodes.
+const shiftedChars = `~!@#$%^&*()_+{}|:"<>?`
+
+func init() {
+	for i := 1; i <= 12; i++ {
+		keysyms[fmt.Sprintf("f%d", i)] = 0xffbe + uint32(i-1)
+	}
+}
+
+// parseKeyCombo parses a key combination like 'ctrl-alt-del', 'f2' or 'a' into keysyms, pressed in order.
+func parseKeyCombo(combo string) ([]uint32, error) {
+	var keys []uint32
+	for _, name := range strings.Split(combo, "-") {
+		lname := strings.ToLower(name)
+		if k, ok := keysyms[lname]; ok {
+			keys = append(keys, k)
+		} else if len([]rune(name)) == 1 && []rune(name)[0] >= 0x20 && []rune(name)[0] < 0x100 {
+			keys = append(keys, uint32([]rune(name)[0]))
+		} else {
+			return nil, fmt.Errorf("unknown key '%s' in '%s'", name, combo)
+		}
+	}
+	return keys, nil
+}
+
+// textKeys returns the key combinations that type text, one per character.
+func textKeys(text string) ([][]uint32, error) {
+	var combos [][]uint32
+	for _, r := range text {
+		switch {
+		case r == '\n':
+			combos = append(combos, []uint32{keysyms["enter"]})
+		case r == '\t':
+			combos = append(combos, []uint32{keysyms["tab"]})
+		case r >= 'A' && r <= 'Z', strings.ContainsRune(shiftedChars, r):
+			combos = append(combos, []uint32{keysyms["shift"], uint32(r)})
+		case r >= 0x20 && r < 0x7f:
+			combos = append(combos, []uint32{uint32(r)})
+		default:
+			return nil, fmt.Errorf("character %q cannot be typed, only ASCII text is supported", r)
+		}
+	}
+	return combos, nil
+}
+
+// KeyEvent presses or releases a key.
+func (r *RFBClient) KeyEvent(key uint32, down bool) error {
+	msg := []byte{4, 0, 0, 0, byte(key >> 24), byte(key >> 16), byte(key >> 8), byte(key)}
+	if down {
+		msg[1] = 1
+	}
+	_, err := r.conn.Write(msg)
+	return err
+}
+
+// SendKeys presses the keys of each combination in order and releases them in reverse order.
+func (r *RFBClient) SendKeys(combos [][]uint32, delay time.Duration) error {
+	for _, keys := range combos {
+		for _, k := range keys {
+			if err := r.KeyEvent(k, true); err != nil {
+				return err
+			}
+		}
+		for i := len(keys) - 1; i >= 0; i-- {
+			if err := r.KeyEvent(keys[i], false); err != nil {
+				return err
+			}
+		}
+		time.Sleep(delay)
+	}
+	return nil
+}
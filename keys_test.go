@@ -0,0 +1,62 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseKeyCombo(t *testing.T) {
+	tests := []struct {
+		combo   string
+		want    []uint32
+		wantErr bool
+	}{
+		{"ctrl-alt-del", []uint32{0xffe3, 0xffe9, 0xffff}, false},
+		{"Ctrl-Alt-Delete", []uint32{0xffe3, 0xffe9, 0xffff}, false},
+		{"f2", []uint32{0xffbf}, false},
+		{"F12", []uint32{0xffc9}, false},
+		{"enter", []uint32{0xff0d}, false},
+		{"a", []uint32{'a'}, false},
+		{"shift-A", []uint32{0xffe1, 'A'}, false},
+		{"ctrl-minus", []uint32{0xffe3, '-'}, false},
+		{"alt-sysrq-b", []uint32{0xffe9, 0xff15, 'b'}, false},
+		{"f13", nil, true},
+		{"ctrl-", nil, true},
+		{"hyper-x", nil, true},
+		{"€", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := parseKeyCombo(tt.combo)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseKeyCombo(%q) error = %v, wantErr %v", tt.combo, err, tt.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseKeyCombo(%q) = %#x, want %#x", tt.combo, got, tt.want)
+		}
+	}
+}
+
+func TestTextKeys(t *testing.T) {
+	tests := []struct {
+		text    string
+		want    [][]uint32
+		wantErr bool
+	}{
+		{"ls\n", [][]uint32{{'l'}, {'s'}, {0xff0d}}, false},
+		{"Hi!", [][]uint32{{0xffe1, 'H'}, {'i'}, {0xffe1, '!'}}, false},
+		{"a\tb", [][]uint32{{'a'}, {0xff09}, {'b'}}, false},
+		{"", nil, false},
+		{"é", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := textKeys(tt.text)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("textKeys(%q) error = %v, wantErr %v", tt.text, err, tt.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("textKeys(%q) = %#x, want %#x", tt.text, got, tt.want)
+		}
+	}
+}
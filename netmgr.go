@@ -7,8 +7,8 @@ import (
 	"net/http"
 )
 
-func (c *QVSClient) NetMgrList() ([]NetMgrNet, error) {
-	reqURL := fmt.Sprintf("%s%s/list?sid=%s", c.QtsURL, QTSNetManager, c.SessionID)
+func (c *QVSClient) netMgrReq(path string) ([]byte, error) {
+	reqURL := fmt.Sprintf("%s%s?sid=%s", c.QtsURL, path, c.SessionID)
 
 	req, _ := http.NewRequest("GET", reqURL, nil)
 	c.reqDebug(req)
@@ -27,10 +27,43 @@ func (c *QVSClient) NetMgrList() ([]NetMgrNet, error) {
 	}
 
 	defer resp.Body.Close()
-	data, _ := ioutil.ReadAll(resp.Body)
+	return ioutil.ReadAll(resp.Body)
+}
+
+func (c *QVSClient) NetMgrList() ([]NetMgrNet, error) {
+	data, err := c.netMgrReq(QTSNetManager + "/list")
+	if err != nil {
+		return nil, err
+	}
 
 	var networks []NetMgrNet
 	json.Unmarshal(data, &networks)
 
 	return networks, nil
 }
+
+// NetMgrNeighbors returns the DHCP leases handed out by the NAS followed by its ARP table.
+// The DHCP server is optional, so a source that fails is skipped as long as the other one works.
+func (c *QVSClient) NetMgrNeighbors() ([]NetMgrNeighbor, error) {
+	var neighbors []NetMgrNeighbor
+	var lastErr error
+	ok := false
+	for _, path := range []string{QTSNetManagerDHCP + "/leases", QTSNetManagerARP + "/list"} {
+		data, err := c.netMgrReq(path)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		var entries []NetMgrNeighbor
+		if err := json.Unmarshal(data, &entries); err != nil {
+			lastErr = err
+			continue
+		}
+		ok = true
+		neighbors = append(neighbors, entries...)
+	}
+	if !ok {
+		return nil, lastErr
+	}
+	return neighbors, nil
+}
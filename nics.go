@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+var nicModels = []string{"virtio", "e1000", "rtl8139"}
+
+// ipAuto as NIC IP allocates a static address from the IP pool of the network.
+const ipAuto = "auto"
+
+type NIC struct {
+	Network string
+	MAC     string
+	Model   string
+	VLAN    int
+
+	// Static guest address in CIDR notation, DHCP is used when empty.
+	IP      string
+	Gateway string
+	DNS     []string
+	Search  []string
+}
+
+// parseNIC parses a --nic spec: network=br0,mac=auto,model=virtio,vlan=20,ip=10.0.0.50/24|auto,gateway=10.0.0.1,dns=10.0.0.2;10.0.0.3,search=lan
+func parseNIC(spec string) (NIC, error) {
+	kv, err := parseKeyValues(spec, []string{"network", "mac", "model", "vlan", "ip", "gateway", "dns", "search"})
+	if err != nil {
+		return NIC{}, err
+	}
+	n := NIC{
+		Network: kv["network"],
+		MAC:     kv["mac"],
+		Model:   kv["model"],
+		IP:      kv["ip"],
+		Gateway: kv["gateway"],
+		DNS:     splitList(kv["dns"]),
+		Search:  splitList(kv["search"]),
+	}
+	if n.Network == "" {
+		return n, fmt.Errorf("nic network is required: %s", spec)
+	}
+	if n.MAC == "auto" {
+		n.MAC = ""
+	}
+	if n.IP == "dhcp" {
+		n.IP = ""
+	}
+	if v, ok := kv["vlan"]; ok {
+		if n.VLAN, err = strconv.Atoi(v); err != nil {
+			return n, fmt.Errorf("invalid nic vlan '%s'", v)
+		}
+	}
+	if err := validateNICModel(n.Model); err != nil {
+		return n, err
+	}
+	if err := validateVLAN(n.VLAN); err != nil {
+		return n, err
+	}
+	if err := n.validateAddressing(); err != nil {
+		return n, err
+	}
+	return n, nil
+}
+
+// validateAddressing checks the static IP settings of a NIC.
+func (n NIC) validateAddressing() error {
+	if n.IP == "" {
+		if n.Gateway != "" {
+			return fmt.Errorf("a gateway requires a static IP on network %s", n.Network)
+		}
+	} else if n.IP == ipAuto {
+		if n.Gateway != "" && net.ParseIP(n.Gateway) == nil {
+			return fmt.Errorf("invalid gateway '%s'", n.Gateway)
+		}
+	} else {
+		ip, subnet, err := net.ParseCIDR(n.IP)
+		if err != nil {
+			return fmt.Errorf("invalid IP '%s', expected an address with prefix length like 10.0.0.50/24", n.IP)
+		}
+		if n.Gateway != "" {
+			gw := net.ParseIP(n.Gateway)
+			if gw == nil {
+				return fmt.Errorf("invalid gateway '%s'", n.Gateway)
+			}
+			if (gw.To4() == nil) != (ip.To4() == nil) {
+				return fmt.Errorf("gateway %s and IP %s are not of the same address family", n.Gateway, n.IP)
+			}
+			if !subnet.Contains(gw) {
+				return fmt.Errorf("gateway %s is not in the subnet of %s", n.Gateway, n.IP)
+			}
+		}
+	}
+	for _, d := range n.DNS {
+		if net.ParseIP(d) == nil {
+			return fmt.Errorf("invalid DNS server '%s'", d)
+		}
+	}
+	return nil
+}
+
+// Static reports whether the NIC has a static IP, the guest configures it with DHCP otherwise.
+func (n NIC) Static() bool {
+	return n.IP != ""
+}
+
+// DefaultRoute returns the destination of the default route in the address family of the NIC IP.
+func (n NIC) DefaultRoute() string {
+	if strings.Contains(n.IP, ":") {
+		return "::/0"
+	}
+	return "0.0.0.0/0"
+}
+
+func validateVLAN(vlan int) error {
+	if vlan < 0 || vlan > 4094 {
+		return fmt.Errorf("invalid nic vlan %d, must be between 1 and 4094, or 0 for untagged", vlan)
+	}
+	return nil
+}
+
+func validateNICModel(model string) error {
+	if model != "" && !stringInSlice(model, nicModels) {
+		return fmt.Errorf("invalid nic model '%s', valid values are: %s", model, strings.Join(nicModels, ", "))
+	}
+	return nil
+}
+
+func (n NIC) CreateRequest() map[string]string {
+	a := map[string]string{
+		"mac":    n.MAC,
+		"bridge": n.Network,
+		"model":  n.Model,
+	}
+	if n.VLAN > 0 {
+		a["vlan"] = fmt.Sprintf("%d", n.VLAN)
+	}
+	return a
+}
+
+// findVMAdapter looks up a VM network adapter by MAC address or index.
+func findVMAdapter(vm VMResponse, ref string) (VMAdaptersResponse, error) {
+	for _, a := range vm.Adapters {
+		if strings.EqualFold(a.MAC, ref) || fmt.Sprintf("%d", a.Index) == ref {
+			return a, nil
+		}
+	}
+	return VMAdaptersResponse{}, fmt.Errorf("network adapter '%s' not found on VM %s", ref, vm.Name)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseNIC(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    NIC
+		wantErr bool
+	}{
+		{"network=br0", NIC{Network: "br0"}, false},
+		{"network=br0,mac=auto,model=virtio,vlan=20", NIC{Network: "br0", Model: "virtio", VLAN: 20}, false},
+		{"network=br1,mac=00:08:9b:aa:bb:cc,model=e1000", NIC{Network: "br1", MAC: "00:08:9b:aa:bb:cc", Model: "e1000"}, false},
+		{"network=br0,ip=dhcp", NIC{Network: "br0"}, false},
+		{"network=br0,ip=10.0.0.50/24,gateway=10.0.0.1,dns=10.0.0.2;10.0.0.3,search=lan;example.com",
+			NIC{Network: "br0", IP: "10.0.0.50/24", Gateway: "10.0.0.1", DNS: []string{"10.0.0.2", "10.0.0.3"}, Search: []string{"lan", "example.com"}}, false},
+		{"network=br0,ip=auto", NIC{Network: "br0", IP: ipAuto}, false},
+		{"network=br0,ip=fd00::10/64,gateway=fd00::1", NIC{Network: "br0", IP: "fd00::10/64", Gateway: "fd00::1"}, false},
+		{"mac=auto", NIC{}, true},
+		{"network=br0,model=ne2k", NIC{}, true},
+		{"network=br0,vlan=x", NIC{}, true},
+		{"network=br0,vlan=4095", NIC{}, true},
+		{"network=br0,ip=10.0.0.50", NIC{}, true},
+		{"network=br0,gateway=10.0.0.1", NIC{}, true},
+		{"network=br0,ip=10.0.0.50/24,gateway=10.0.1.1", NIC{}, true},
+		{"network=br0,ip=10.0.0.50/24,gateway=fd00::1", NIC{}, true},
+		{"network=br0,ip=10.0.0.50/24,dns=dns.lan", NIC{}, true},
+		{"network=br0,bridge=br1", NIC{}, true},
+	}
+	for _, tt := range tests {
+		got, err := parseNIC(tt.spec)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseNIC(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseNIC(%q) = %+v, want %+v", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestNICCreateRequest(t *testing.T) {
+	tests := []struct {
+		nic  NIC
+		want map[string]string
+	}{
+		{NIC{Network: "br0", MAC: "00:08:9b:aa:bb:cc", Model: "virtio"}, map[string]string{"mac": "00:08:9b:aa:bb:cc", "bridge": "br0", "model": "virtio"}},
+		{NIC{Network: "br0", MAC: "00:08:9b:aa:bb:cc", Model: "e1000", VLAN: 20}, map[string]string{"mac": "00:08:9b:aa:bb:cc", "bridge": "br0", "model": "e1000", "vlan": "20"}},
+	}
+	for _, tt := range tests {
+		if got := tt.nic.CreateRequest(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("CreateRequest of %+v = %v, want %v", tt.nic, got, tt.want)
+		}
+	}
+}
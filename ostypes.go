@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+type OSType struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Family    string `json:"family"`
+	DiskBus   string `json:"disk_bus"`
+	NICModel  string `json:"nic_model"`
+	CloudInit bool   `json:"cloud_init"`
+}
+
+const defaultOSType = "linux"
+
+// Aliases for the generic os types accepted by earlier versions.
+var osTypeAliases = map[string]string{
+	"linux":   "ubuntuzesty",
+	"windows": "win100",
+}
+
+func linuxOS(id, name string) OSType {
+	return OSType{ID: id, Name: name, Family: "linux", DiskBus: "virtio", NICModel: "virtio", CloudInit: true}
+}
+
+func bsdOS(id, name string) OSType {
+	return OSType{ID: id, Name: name, Family: "bsd", DiskBus: "virtio", NICModel: "virtio", CloudInit: false}
+}
+
+func windowsOS(id, name string) OSType {
+	return OSType{ID: id, Name: name, Family: "windows", DiskBus: "sata", NICModel: "e1000", CloudInit: false}
+}
+
+// osTypes is the catalog of QVS os_type identifiers.
+var osTypes = []OSType{
+	linuxOS("ubuntutrusty", "Ubuntu 14.04 LTS"),
+	linuxOS("ubuntuxenial", "Ubuntu 16.04 LTS"),
+	linuxOS("ubuntuzesty", "Ubuntu 17.04"),
+	linuxOS("ubuntuartful", "Ubuntu 17.10"),
+	linuxOS("ubuntubionic", "Ubuntu 18.04 LTS"),
+	linuxOS("debian8", "Debian 8"),
+	linuxOS("debian9", "Debian 9"),
+	linuxOS("centos6.9", "CentOS 6"),
+	linuxOS("centos7.0", "CentOS 7"),
+	linuxOS("rhel6.9", "Red Hat Enterprise Linux 6"),
+	linuxOS("rhel7.0", "Red Hat Enterprise Linux 7"),
+	linuxOS("fedora26", "Fedora 26"),
+	linuxOS("fedora27", "Fedora 27"),
+	linuxOS("fedora28", "Fedora 28"),
+	bsdOS("freebsd10.4", "FreeBSD 10"),
+	bsdOS("freebsd11.1", "FreeBSD 11"),
+	windowsOS("win7", "Windows 7"),
+	windowsOS("win8", "Windows 8"),
+	windowsOS("win81", "Windows 8.1"),
+	windowsOS("win100", "Windows 10"),
+	windowsOS("win2k8r2", "Windows Server 2008 R2"),
+	windowsOS("win2k12r2", "Windows Server 2012 R2"),
+	windowsOS("win2k16", "Windows Server 2016"),
+	{ID: "other", Name: "Other", Family: "other", DiskBus: "sata", NICModel: "e1000", CloudInit: false},
+}
+
+// lookupOSType finds an os type in the catalog by QVS identifier or alias.
+func lookupOSType(id string) (OSType, error) {
+	id = strings.ToLower(strings.TrimSpace(id))
+	if alias, ok := osTypeAliases[id]; ok {
+		id = alias
+	}
+	for _, o := range osTypes {
+		if o.ID == id {
+			return o, nil
+		}
+	}
+	return OSType{}, fmt.Errorf("unknown os type '%s', get valid os types from 'qvscli os-types list'", id)
+}
@@ -0,0 +1,29 @@
+package main
+
+import "testing"
+
+func TestLookupOSType(t *testing.T) {
+	tests := []struct {
+		id      string
+		want    string
+		wantErr bool
+	}{
+		{"ubuntubionic", "ubuntubionic", false},
+		{" Win2k16 ", "win2k16", false},
+		{"linux", "ubuntuzesty", false},
+		{"windows", "win100", false},
+		{defaultOSType, "ubuntuzesty", false},
+		{"plan9", "", true},
+		{"", "", true},
+	}
+	for _, tt := range tests {
+		got, err := lookupOSType(tt.id)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("lookupOSType(%q) error = %v, wantErr %v", tt.id, err, tt.wantErr)
+			continue
+		}
+		if got.ID != tt.want {
+			t.Errorf("lookupOSType(%q) = %s, want %s", tt.id, got.ID, tt.want)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// To is empty for actions that end in the state they start from, there is no state change to wait for.
+type powerAction struct {
+	From []string
+	To   string
+	Done string
+}
+
+var powerActions = map[string]powerAction{
+	"start":         {From: []string{QVSPowerStateStop}, To: QVSPowerStateRunning, Done: "started VM"},
+	"reset":         {From: []string{QVSPowerStateRunning}, Done: "reset VM"},
+	"reboot":        {From: []string{QVSPowerStateRunning}, Done: "Sent ACPI reboot signal to VM"},
+	"shutdown":      {From: []string{QVSPowerStateRunning}, To: QVSPowerStateStop, Done: "Sent ACPI shutdown signal to VM"},
+	"forceshutdown": {From: []string{QVSPowerStateRunning, QVSPowerStatePaused}, To: QVSPowerStateStop, Done: "VM stopped"},
+	"suspend":       {From: []string{QVSPowerStateRunning}, To: QVSPowerStatePaused, Done: "suspended VM"},
+	"resume":        {From: []string{QVSPowerStatePaused}, To: QVSPowerStateRunning, Done: "resumed VM"},
+}
+
+// checkPowerAction returns an error if the power action makes no sense for the current power state of the VM.
+func checkPowerAction(vm VMResponse, action string) error {
+	a, ok := powerActions[action]
+	if !ok {
+		return fmt.Errorf("unknown power action: %s", action)
+	}
+	if stringInSlice(vm.PowerState, a.From) {
+		return nil
+	}
+	hint := ""
+	switch {
+	case vm.PowerState == QVSPowerStatePaused && action != "resume":
+		hint = ", resume it first"
+	case a.To != "" && vm.PowerState == a.To:
+		hint = ", nothing to do"
+	}
+	return fmt.Errorf("cannot %s VM %s, it is %s and must be %s%s", action, vm.Name, vm.PowerState, strings.Join(a.From, " or "), hint)
+}
+
+func (c *QVSClient) VMPowerAction(id string, action string) error {
+	switch action {
+	case "start":
+		return c.VMStart(id)
+	case "reset":
+		return c.VMReset(id)
+	case "reboot":
+		return c.VMReboot(id)
+	case "shutdown":
+		return c.VMShutdown(id, false)
+	case "forceshutdown":
+		return c.VMShutdown(id, true)
+	case "suspend":
+		return c.VMSuspend(id)
+	case "resume":
+		return c.VMResume(id)
+	}
+	return fmt.Errorf("unknown power action: %s", action)
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"path/filepath"
+)
+
+const qcow2Magic = 0x514649fb
+const qcow2Version = 3
+const qcow2ClusterBits = 16
+const qcow2ClusterSize = 1 << qcow2ClusterBits
+const qcow2HeaderLength = 104
+const qcow2RefcountOrder = 4
+const qcow2ExtBackingFormat = 0xe2792aca
+
+// Qcow2Header holds the fields of a qcow2 image header needed to layer overlays on top of it.
+type Qcow2Header struct {
+	Version     uint32
+	Size        uint64
+	BackingFile string
+}
+
+// parseQcow2Header parses the header of a qcow2 image from the first bytes of the file.
+// The data must include the backing file name if the image has one.
+func parseQcow2Header(data []byte) (Qcow2Header, error) {
+	var h Qcow2Header
+	if len(data) < 72 {
+		return h, fmt.Errorf("qcow2 header too short: %d bytes", len(data))
+	}
+	if binary.BigEndian.Uint32(data[0:4]) != qcow2Magic {
+		return h, fmt.Errorf("not a qcow2 image")
+	}
+	h.Version = binary.BigEndian.Uint32(data[4:8])
+	h.Size = binary.BigEndian.Uint64(data[24:32])
+
+	backingOffset := binary.BigEndian.Uint64(data[8:16])
+	backingSize := uint64(binary.BigEndian.Uint32(data[16:20]))
+	if backingOffset != 0 {
+		if backingOffset+backingSize > uint64(len(data)) {
+			return h, fmt.Errorf("qcow2 backing file name at offset %d is outside of the %d bytes read", backingOffset, len(data))
+		}
+		h.BackingFile = string(data[backingOffset : backingOffset+backingSize])
+	}
+
+	return h, nil
+}
+
+// isQcow2 returns true if data starts with the qcow2 magic number.
+func isQcow2(data []byte) bool {
+	return len(data) >= 4 && binary.BigEndian.Uint32(data[0:4]) == qcow2Magic
+}
+
+// makeQcow2Overlay generates an empty qcow2 (v3) image of the given virtual size that uses
+// backingFile as its backing file. The layout matches what 'qemu-img create' produces:
+// header in cluster 0, refcount table in cluster 1, refcount block in cluster 2 and the L1 table after that.
+func makeQcow2Overlay(backingFile, backingFormat string, size uint64) ([]byte, error) {
+	if size == 0 {
+		return nil, fmt.Errorf("invalid qcow2 virtual size: 0")
+	}
+
+	// Each L2 table fills one cluster of 8 byte entries, each entry maps one cluster.
+	l2Coverage := uint64(qcow2ClusterSize/8) * qcow2ClusterSize
+	l1Size := (size + l2Coverage - 1) / l2Coverage
+	l1Clusters := (l1Size*8 + qcow2ClusterSize - 1) / qcow2ClusterSize
+
+	refcountTableOffset := uint64(1 * qcow2ClusterSize)
+	refcountBlockOffset := uint64(2 * qcow2ClusterSize)
+	l1TableOffset := uint64(3 * qcow2ClusterSize)
+	totalClusters := 3 + l1Clusters
+
+	// 16 bit refcounts, a single refcount block covers the whole metadata area.
+	if totalClusters > qcow2ClusterSize/2 {
+		return nil, fmt.Errorf("qcow2 virtual size too large: %d", size)
+	}
+
+	var hdr bytes.Buffer
+	be := binary.BigEndian
+	binary.Write(&hdr, be, uint32(qcow2Magic))
+	binary.Write(&hdr, be, uint32(qcow2Version))
+	binary.Write(&hdr, be, uint64(0)) // backing_file_offset, patched below
+	binary.Write(&hdr, be, uint32(len(backingFile)))
+	binary.Write(&hdr, be, uint32(qcow2ClusterBits))
+	binary.Write(&hdr, be, size)
+	binary.Write(&hdr, be, uint32(0)) // crypt_method
+	binary.Write(&hdr, be, uint32(l1Size))
+	binary.Write(&hdr, be, l1TableOffset)
+	binary.Write(&hdr, be, refcountTableOffset)
+	binary.Write(&hdr, be, uint32(1)) // refcount_table_clusters
+	binary.Write(&hdr, be, uint32(0)) // nb_snapshots
+	binary.Write(&hdr, be, uint64(0)) // snapshots_offset
+	binary.Write(&hdr, be, uint64(0)) // incompatible_features
+	binary.Write(&hdr, be, uint64(0)) // compatible_features
+	binary.Write(&hdr, be, uint64(0)) // autoclear_features
+	binary.Write(&hdr, be, uint32(qcow2RefcountOrder))
+	binary.Write(&hdr, be, uint32(qcow2HeaderLength))
+
+	// Header extensions, padded to 8 bytes.
+	if backingFormat != "" {
+		binary.Write(&hdr, be, uint32(qcow2ExtBackingFormat))
+		binary.Write(&hdr, be, uint32(len(backingFormat)))
+		hdr.WriteString(backingFormat)
+		if pad := len(backingFormat) % 8; pad != 0 {
+			hdr.Write(make([]byte, 8-pad))
+		}
+	}
+	binary.Write(&hdr, be, uint32(0)) // end of extensions
+	binary.Write(&hdr, be, uint32(0))
+
+	backingOffset := hdr.Len()
+	hdr.WriteString(backingFile)
+	if hdr.Len() > qcow2ClusterSize {
+		return nil, fmt.Errorf("qcow2 backing file name too long: %s", backingFile)
+	}
+
+	img := make([]byte, totalClusters*qcow2ClusterSize)
+	copy(img, hdr.Bytes())
+	be.PutUint64(img[8:16], uint64(backingOffset))
+
+	// Refcount table points to the single refcount block.
+	be.PutUint64(img[refcountTableOffset:], refcountBlockOffset)
+
+	// Every allocated cluster has a refcount of 1, the L1 table is empty.
+	for i := uint64(0); i < totalClusters; i++ {
+		be.PutUint16(img[refcountBlockOffset+i*2:], 1)
+	}
+
+	return img, nil
+}
+
+// relBackingPath returns the path of backingPath relative to the directory of the overlay at overlayPath,
+// qemu resolves relative backing file names from the overlay location.
+func relBackingPath(overlayPath, backingPath string) (string, error) {
+	return filepath.Rel(filepath.Dir(overlayPath), backingPath)
+}
+
+// resolveBackingPath returns the absolute path of the backing file of the overlay at overlayPath.
+func resolveBackingPath(overlayPath, backingFile string) string {
+	if filepath.IsAbs(backingFile) {
+		return filepath.Clean(backingFile)
+	}
+	return filepath.Join(filepath.Dir(overlayPath), backingFile)
+}
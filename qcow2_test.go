@@ -0,0 +1,90 @@
+package main
+
+import "testing"
+
+func TestQcow2OverlayRoundTrip(t *testing.T) {
+	tests := []struct {
+		backingFile   string
+		backingFormat string
+		size          uint64
+	}{
+		{"../base.img", "raw", 10 << 30},
+		{"base.qcow2", "qcow2", 1 << 20},
+		{"/share/images/ubuntu.img", "", 40 << 30},
+	}
+	for _, tt := range tests {
+		img, err := makeQcow2Overlay(tt.backingFile, tt.backingFormat, tt.size)
+		if err != nil {
+			t.Fatalf("makeQcow2Overlay(%q, %q, %d): %v", tt.backingFile, tt.backingFormat, tt.size, err)
+		}
+		if !isQcow2(img) {
+			t.Errorf("isQcow2 is false for the overlay of %s", tt.backingFile)
+		}
+		h, err := parseQcow2Header(img)
+		if err != nil {
+			t.Fatalf("parseQcow2Header of the overlay of %s: %v", tt.backingFile, err)
+		}
+		want := Qcow2Header{Version: qcow2Version, Size: tt.size, BackingFile: tt.backingFile}
+		if h != want {
+			t.Errorf("parseQcow2Header = %+v, want %+v", h, want)
+		}
+		if len(img)%qcow2ClusterSize != 0 {
+			t.Errorf("overlay of %s is %d bytes, not a multiple of the cluster size", tt.backingFile, len(img))
+		}
+	}
+}
+
+func TestQcow2OverlayErrors(t *testing.T) {
+	if _, err := makeQcow2Overlay("base.img", "raw", 0); err == nil {
+		t.Error("makeQcow2Overlay with size 0: expected an error")
+	}
+	long := make([]byte, qcow2ClusterSize)
+	for i := range long {
+		long[i] = 'a'
+	}
+	if _, err := makeQcow2Overlay(string(long), "raw", 1<<30); err == nil {
+		t.Error("makeQcow2Overlay with a backing file name longer than a cluster: expected an error")
+	}
+}
+
+func TestParseQcow2HeaderErrors(t *testing.T) {
+	img, _ := makeQcow2Overlay("base.img", "raw", 1<<30)
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"short", img[:16]},
+		{"not qcow2", make([]byte, 512)},
+		{"truncated backing file", img[:100]},
+	}
+	for _, tt := range tests {
+		if _, err := parseQcow2Header(tt.data); err == nil {
+			t.Errorf("%s: expected an error", tt.name)
+		}
+		if tt.name == "not qcow2" && isQcow2(tt.data) {
+			t.Errorf("%s: isQcow2 is true", tt.name)
+		}
+	}
+}
+
+func TestResolveBackingPath(t *testing.T) {
+	tests := []struct {
+		overlay, backing, want string
+	}{
+		{"/share/VMs/web/disk.qcow2", "../images/base.img", "/share/VMs/images/base.img"},
+		{"/share/VMs/web/disk.qcow2", "/share/images/base.img", "/share/images/base.img"},
+		{"/share/VMs/web/disk.qcow2", "base.img", "/share/VMs/web/base.img"},
+	}
+	for _, tt := range tests {
+		if got := resolveBackingPath(tt.overlay, tt.backing); got != tt.want {
+			t.Errorf("resolveBackingPath(%q, %q) = %q, want %q", tt.overlay, tt.backing, got, tt.want)
+		}
+		rel, err := relBackingPath(tt.overlay, tt.want)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := resolveBackingPath(tt.overlay, rel); got != tt.want {
+			t.Errorf("relBackingPath(%q, %q) = %q does not resolve back", tt.overlay, tt.want, rel)
+		}
+	}
+}
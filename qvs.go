@@ -8,7 +8,10 @@ import (
 	"io/ioutil"
 	"log"
 	"net/http"
+	"os"
 	"path/filepath"
+	"strings"
+	"time"
 )
 
 func (c *QVSClient) qvsReq(method string, path string, data string) (*http.Response, error) {
@@ -148,39 +151,39 @@ func (c *QVSClient) QVSListNet() ([]QVSNet, error) {
 	return networks, nil
 }
 
-func (c *QVSClient) VMCreate(name string, description string, osType string, cores int, memGB int, network string, mac string, bootISOPath string, diskImagePath string, vncPassword string) error {
+// QVSNetName returns the bridge name of a network given by bridge or display name.
+func (c *QVSClient) QVSNetName(name string) (string, error) {
+	if name == "" {
+		return "", fmt.Errorf("no network provided")
+	}
+	networks, err := c.QVSListNet()
+	if err != nil {
+		return "", err
+	}
+	for _, n := range networks {
+		if n.Name == name || n.DisplayName == name {
+			return n.Name, nil
+		}
+	}
+	return "", fmt.Errorf("network %s not found, get names from 'qvscli net list'", name)
+}
+
+func (c *QVSClient) VMCreate(name string, description string, osType string, cores int, memory int64, adapters []map[string]string, cdroms []map[string]string, disks []map[string]string, serials []map[string]string, vncPassword string, hw QVSHardwareRequest) error {
 	var vm QVSCreateRequest
+	vm.QVSHardwareRequest = hw
 	vm.Name = name
 	vm.Description = description
 
-	if osType == "linux" {
-		vm.OSType = "ubuntuzesty"
-	} else {
-		vm.OSType = "win100"
-	}
+	vm.OSType = osType
 
 	vm.IsAgentEnabled = true
 
 	vm.Cores = cores
-	vm.Memory = memGB * 1024 * 1024 * 1024
-	vm.Adapters = []map[string]string{
-		{
-			"mac":    mac,
-			"bridge": network,
-			"model":  "virtio",
-		},
-	}
-	vm.CDROMs = []map[string]string{
-		{
-			"path": bootISOPath,
-		},
-	}
-	vm.Disks = []map[string]string{
-		{
-			"creating_image": "false",
-			"path":           diskImagePath,
-		},
-	}
+	vm.Memory = memory
+	vm.Adapters = adapters
+	vm.CDROMs = cdroms
+	vm.Disks = disks
+	vm.Serials = serials
 	if vncPassword != "" {
 		passwordBase64 := base64.StdEncoding.EncodeToString([]byte(vncPassword))
 
@@ -220,6 +223,33 @@ func (c *QVSClient) VMReset(id string) error {
 	return nil
 }
 
+func (c *QVSClient) VMReboot(id string) error {
+	_, err := c.qvsReq("POST", fmt.Sprintf(QVSVMReboot, id), "{}")
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (c *QVSClient) VMSuspend(id string) error {
+	_, err := c.qvsReq("POST", fmt.Sprintf(QVSVMSuspend, id), "{}")
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (c *QVSClient) VMResume(id string) error {
+	_, err := c.qvsReq("POST", fmt.Sprintf(QVSVMResume, id), "{}")
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
 func (c *QVSClient) VMShutdown(id string, force bool) error {
 	pathTpl := QVSVMShutdown
 	if force {
@@ -233,6 +263,66 @@ func (c *QVSClient) VMShutdown(id string, force bool) error {
 	return nil
 }
 
+func (c *QVSClient) VMUpdate(id string, update QVSUpdateRequest) error {
+	jsonData, _ := json.Marshal(&update)
+	_, err := c.qvsReq("PUT", fmt.Sprintf("%s/%s", QVSVMs, id), string(jsonData))
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (c *QVSClient) VMWaitState(idOrName string, state string, timeout time.Duration) (VMResponse, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		vm, err := c.VMGet(idOrName)
+		if err != nil {
+			return vm, err
+		}
+		if vm.PowerState == state {
+			return vm, nil
+		}
+		if time.Now().After(deadline) {
+			return vm, fmt.Errorf("timed out after %s waiting for VM %s to be %s, current state: %s", timeout, vm.Name, state, vm.PowerState)
+		}
+		time.Sleep(2 * time.Second)
+	}
+}
+
+// VMMoveFolder renames the folder of a stopped VM in disksDir to newName and points the disks and CD-ROM images in it
+// to the new folder.
+func (c *QVSClient) VMMoveFolder(vm VMResponse, disksDir, newName string) error {
+	oldDir, err := vmFolder(disksDir, vm.Name)
+	if err != nil {
+		return err
+	}
+	newDir, err := vmFolder(disksDir, newName)
+	if err != nil {
+		return err
+	}
+	if err := c.RenameFile(disksDir, vm.Name, newName); err != nil {
+		return err
+	}
+	log.Printf("INFO: Moved VM folder %s to %s", oldDir, newDir)
+	id := fmt.Sprintf("%d", vm.ID)
+	for _, d := range vm.Disks {
+		if dir, ok := diskTopFolder(disksDir, d.Path); ok && dir == oldDir {
+			if err := c.VMDiskUpdate(id, d.ID, map[string]string{"path": newDir + strings.TrimPrefix(d.Path, oldDir)}); err != nil {
+				return err
+			}
+		}
+	}
+	for _, cd := range vm.CDROMs {
+		if dir, ok := diskTopFolder(disksDir, cd.Path); ok && dir == oldDir {
+			if err := c.VMCDROMUpdate(id, cd.ID, map[string]string{"path": newDir + strings.TrimPrefix(cd.Path, oldDir)}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 func (c *QVSClient) VMDelete(id string) error {
 	_, err := c.qvsReq("DELETE", fmt.Sprintf("%s/%s", QVSVMs, id), "{}")
 	if err != nil {
@@ -242,6 +332,147 @@ func (c *QVSClient) VMDelete(id string) error {
 	return nil
 }
 
+func (c *QVSClient) VMDiskAdd(id string, disk map[string]string) error {
+	jsonData, _ := json.Marshal(disk)
+	_, err := c.qvsReq("POST", fmt.Sprintf(QVSVMDisks, id), string(jsonData))
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (c *QVSClient) VMDiskUpdate(id string, diskID int, changes map[string]string) error {
+	jsonData, _ := json.Marshal(changes)
+	_, err := c.qvsReq("PUT", fmt.Sprintf(QVSVMDisk, id, diskID), string(jsonData))
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (c *QVSClient) VMDiskRemove(id string, diskID int) error {
+	_, err := c.qvsReq("DELETE", fmt.Sprintf(QVSVMDisk, id, diskID), "{}")
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (c *QVSClient) VMCDROMAdd(id string, cdrom map[string]string) error {
+	jsonData, _ := json.Marshal(cdrom)
+	_, err := c.qvsReq("POST", fmt.Sprintf(QVSVMCDROMs, id), string(jsonData))
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (c *QVSClient) VMCDROMUpdate(id string, cdromID int, changes map[string]string) error {
+	jsonData, _ := json.Marshal(changes)
+	_, err := c.qvsReq("PUT", fmt.Sprintf(QVSVMCDROM, id, cdromID), string(jsonData))
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// VMGraphicsUpdate sets the VNC password of a VM, an empty password disables it.
+func (c *QVSClient) VMGraphicsUpdate(id string, graphicsID int, vncPassword string) error {
+	graphics := QVSCreateGraphicsRequest{Type: "vnc"}
+	if vncPassword != "" {
+		graphics.EnablePassword = true
+		graphics.Password = base64.StdEncoding.EncodeToString([]byte(vncPassword))
+	}
+	jsonData, _ := json.Marshal(&graphics)
+	_, err := c.qvsReq("PUT", fmt.Sprintf(QVSVMGraphics, id, graphicsID), string(jsonData))
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (c *QVSClient) VMAdapterAdd(id string, adapter map[string]string) error {
+	jsonData, _ := json.Marshal(adapter)
+	_, err := c.qvsReq("POST", fmt.Sprintf(QVSVMAdapters, id), string(jsonData))
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (c *QVSClient) VMAdapterUpdate(id string, adapterID int, changes map[string]string) error {
+	jsonData, _ := json.Marshal(changes)
+	_, err := c.qvsReq("PUT", fmt.Sprintf(QVSVMAdapter, id, adapterID), string(jsonData))
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (c *QVSClient) VMAdapterRemove(id string, adapterID int) error {
+	_, err := c.qvsReq("DELETE", fmt.Sprintf(QVSVMAdapter, id, adapterID), "{}")
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (c *QVSClient) VMSnapshotList(id string) ([]VMSnapshotResponse, error) {
+	resp, err := c.qvsReq("GET", fmt.Sprintf(QVSVMSnapshots, id), "")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var snapList VMSnapshotListResponse
+	err = json.NewDecoder(resp.Body).Decode(&snapList)
+	if err != nil {
+		return nil, err
+	}
+
+	return snapList.Data, err
+}
+
+func (c *QVSClient) VMSnapshotCreate(id string, name string, description string) (VMSnapshotResponse, error) {
+	jsonData, _ := json.Marshal(&QVSSnapshotRequest{Name: name, Description: description})
+	_, err := c.qvsReq("POST", fmt.Sprintf(QVSVMSnapshots, id), string(jsonData))
+	if err != nil {
+		return VMSnapshotResponse{}, err
+	}
+
+	// Snapshot creation may be deferred, wait for it to be listed.
+	for i := 0; i < 60; i++ {
+		snaps, err := c.VMSnapshotList(id)
+		if err != nil {
+			return VMSnapshotResponse{}, err
+		}
+		for _, s := range snaps {
+			if s.Name == name {
+				return s, nil
+			}
+		}
+		time.Sleep(2 * time.Second)
+	}
+	return VMSnapshotResponse{}, fmt.Errorf("timed out waiting for snapshot %s of VM %s", name, id)
+}
+
+func (c *QVSClient) VMSnapshotDelete(id string, snapID int) error {
+	_, err := c.qvsReq("DELETE", fmt.Sprintf(QVSVMSnapshot, id, fmt.Sprintf("%d", snapID)), "{}")
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
 func (c *QVSClient) VMDiskSnapshotCreate(vmID, name, snapDir string) (string, error) {
 	vm, err := c.VMGet(vmID)
 	if err != nil {
@@ -287,3 +518,103 @@ func (c *QVSClient) VMDiskSnapshotCreate(vmID, name, snapDir string) (string, er
 
 	return destPath, nil
 }
+
+// ImageInfo returns the format and virtual size of an image, the error satisfies os.IsNotExist if it is missing.
+func (c *QVSClient) ImageInfo(imagePath string) (string, uint64, error) {
+	head, err := c.ReadFileHead(imagePath, qcow2ClusterSize)
+	if err != nil {
+		return "", 0, err
+	}
+	if isQcow2(head) {
+		h, err := parseQcow2Header(head)
+		if err != nil {
+			return "", 0, err
+		}
+		return "qcow2", h.Size, nil
+	}
+
+	// Raw image, virtual size is the file size.
+	files, err := c.ListDir(filepath.Dir(imagePath))
+	if err != nil {
+		return "", 0, err
+	}
+	for _, f := range files {
+		if f.Filename == filepath.Base(imagePath) {
+			return "raw", uint64(f.Filesize), nil
+		}
+	}
+	return "", 0, &os.PathError{Op: "image info", Path: imagePath, Err: os.ErrNotExist}
+}
+
+func (c *QVSClient) CreateLinkedDisk(basePath, overlayPath string, size uint64) error {
+	format, baseSize, err := c.ImageInfo(basePath)
+	if err != nil {
+		return err
+	}
+	if size < baseSize {
+		size = baseSize
+	}
+	backingFile, err := relBackingPath(overlayPath, basePath)
+	if err != nil {
+		return err
+	}
+	img, err := makeQcow2Overlay(backingFile, format, size)
+	if err != nil {
+		return err
+	}
+
+	dir, err := ioutil.TempDir("", "qvs-linked-disk")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	tmpFile := filepath.Join(dir, filepath.Base(overlayPath))
+	if err := ioutil.WriteFile(tmpFile, img, 0644); err != nil {
+		return err
+	}
+	f, err := os.Open(tmpFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return c.UploadFile(f, overlayPath)
+}
+
+// ImageReferences returns the VMs with qcow2 disks that use imagePath as their backing file.
+// Disks that can't be read are skipped with a warning.
+func (c *QVSClient) ImageReferences(imagePath string) ([]VMResponse, error) {
+	vms, err := c.VMList()
+	if err != nil {
+		return nil, err
+	}
+	imagePath = filepath.Clean(imagePath)
+
+	var refs []VMResponse
+	for _, v := range vms {
+		for _, d := range v.Disks {
+			if d.Format != "qcow2" {
+				continue
+			}
+			head, err := c.ReadFileHead(d.Path, qcow2ClusterSize)
+			if err != nil {
+				log.Printf("WARN: skipping disk %s of VM %s, failed to read it: %v", d.Path, v.Name, err)
+				continue
+			}
+			if !isQcow2(head) {
+				continue
+			}
+			h, err := parseQcow2Header(head)
+			if err != nil {
+				log.Printf("WARN: skipping disk %s of VM %s: %v", d.Path, v.Name, err)
+				continue
+			}
+			if h.BackingFile != "" && resolveBackingPath(d.Path, h.BackingFile) == imagePath {
+				refs = append(refs, v)
+				break
+			}
+		}
+	}
+
+	return refs, nil
+}
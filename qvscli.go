@@ -2,21 +2,23 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"image/png"
+	"io"
 	"io/ioutil"
 	"log"
+	"net"
 	"os"
 	"path/filepath"
 	"regexp"
 	"sort"
+	"strconv"
 	"strings"
 	"text/tabwriter"
-	"text/template"
 	"time"
 
-	"github.com/Masterminds/sprig"
-
 	"github.com/sethvargo/go-password/password"
 	"github.com/urfave/cli"
 )
@@ -30,11 +32,17 @@ func main() {
 	var defaultLoginFile = fmt.Sprintf("%s/.qvs_login", os.Getenv("HOME"))
 	var defaultPubKeyFile = filepath.Join(os.Getenv("HOME"), ".ssh", "id_rsa.pub")
 	var loginFile string
+	var defaultSecretsFile = filepath.Join(os.Getenv("HOME"), ".qvs_secrets")
+	var secretsFile string
 	var metaDataFile string
 	var userDataFile string
 	var vmStartupScript string
 	var noCloudInit bool
 	var vmImage string
+	var vmLinked bool
+	var vmOSType string
+	var vmFromISO string
+	var vmDiskSize string
 	var vmMACAddress string
 	var vmNetName string
 	var vmDescription string
@@ -48,6 +56,70 @@ func main() {
 	var vmAuthorizedKey string
 	var vmVNCPassword string
 	var vmSnapshotIDOrName string
+	var diskSize string
+	var diskPath string
+	var diskBus string
+	var diskCache string
+	var diskFormat string
+	var diskDeleteFile bool
+	var nicNetwork string
+	var nicMAC string
+	var nicModel string
+	var nicVLAN int
+	var vmSetCores int
+	var vmSetMemory string
+	var vmSetName string
+	var vmSetDescription string
+	var vmSetHotplug bool
+	var vmSetRestart bool
+	var vmWait bool
+	var hwFirmware string
+	var hwSecureBoot bool
+	var hwMachine string
+	var hwCPUModel string
+	var hwSockets int
+	var hwThreads int
+	var hwBootOrder string
+	var hwAutostart bool
+	var hwNoAutostart bool
+	var cdromIndex int
+	var cdromDeleteFile bool
+	var vmEjectSeed bool
+	var vmSeedEjectDelay time.Duration
+	var vmWaitTimeout time.Duration
+	var vmAnswerFile string
+	var vmAnswerTemplate string
+	var vmInstallUser string
+	var vmTimezone string
+	var vmLocale string
+	var vmKeyboard string
+	var vmDiskLayout string
+	var vmWindowsImage string
+	var vmProductKey string
+	var vmGenerateKey bool
+	var printSecrets bool
+	var vmUsersFile string
+	var networkConfigFile string
+	var vmIP string
+	var vmGateway string
+	var ipamFile string
+	var templateValuesFile string
+	var seedTemplate bool
+	var poolCIDR string
+	var poolRange string
+	var poolGateway string
+	var sshUser string
+	var scpRecursive bool
+	var consoleListen string
+	var consoleOpen bool
+	var consoleViewer string
+	var consoleWebsocket bool
+	var consolePrintURL bool
+	var screenshotFile string
+	var typeText string
+	var keyDelay time.Duration
+	var vmSerialPort int
+	var serialLog string
 
 	getClient := func() *QVSClient {
 		client, err := NewQVSClient(qtsURL, loginFile, false, httpDebug)
@@ -57,6 +129,252 @@ func main() {
 		return client
 	}
 
+	// getIPAMFile returns the NAS path of the IPAM ledger, next to the VM disks by default.
+	getIPAMFile := func() string {
+		if ipamFile != "" {
+			return ipamFile
+		}
+		return filepath.Join(qvsDisksDir, ipamFileName)
+	}
+
+	getSecrets := func() *SecretsStore {
+		secrets, err := loadSecrets(secretsFile, promptPassphrase)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return secrets
+	}
+
+	// vncPassword returns the password from --vnc-password, or the one stored when the VM was created.
+	vncPassword := func(vm VMResponse) string {
+		if vmVNCPassword != "" {
+			return vmVNCPassword
+		}
+		return getSecrets().Get(vm.UUID, secretVNCPassword)
+	}
+
+	vmPower := func(idOrName string, action string) error {
+		client := getClient()
+		vm, err := client.VMGet(idOrName)
+		if err != nil {
+			return err
+		}
+		if err := checkPowerAction(vm, action); err != nil {
+			return err
+		}
+		id := fmt.Sprintf("%d", vm.ID)
+		if err := client.VMPowerAction(id, action); err != nil {
+			return err
+		}
+		log.Printf("INFO: %s: %s", powerActions[action].Done, vm.Name)
+
+		if vmWait && powerActions[action].To != "" {
+			vm, err = client.VMWaitState(id, powerActions[action].To, vmWaitTimeout)
+			if err != nil {
+				return err
+			}
+			log.Printf("INFO: VM %s is %s", vm.Name, vm.PowerState)
+		}
+		return nil
+	}
+
+	cloudInitFlags := []cli.Flag{
+		cli.StringFlag{
+			Name:        "startup-script",
+			Value:       "",
+			Usage:       "Path to startup script to run as runcmd action in cloud-init",
+			Destination: &vmStartupScript,
+			EnvVar:      "QVSCLI_STARTUP_SCRIPT",
+		},
+		cli.StringFlag{
+			Name:        "meta-data",
+			Value:       "",
+			Usage:       "Path to meta-data file override for cloud-init, rendered with --template like --user-data. Default is generated automatically",
+			Destination: &metaDataFile,
+			EnvVar:      "QVSCLI_META_DATA_FILE",
+		},
+		cli.StringFlag{
+			Name:        "user-data",
+			Value:       "",
+			Usage:       "Path to user-data file for cloud-init. With --template it is rendered as a Go template with the sprig functions, available are .Name, .MAC, .IP, .NICs, .TS, .Timestamp, .Image and .Values",
+			Destination: &userDataFile,
+			EnvVar:      "QVSCLI_USER_DATA_FILE",
+		},
+		cli.StringFlag{
+			Name:        "network-config",
+			Value:       "",
+			Usage:       "Path to network-config file override for cloud-init, rendered with --template like --user-data. Default is generated when a NIC has a static IP",
+			Destination: &networkConfigFile,
+			EnvVar:      "QVSCLI_NETWORK_CONFIG_FILE",
+		},
+		cli.StringFlag{
+			Name:        "authorized-key",
+			Value:       defaultPubKeyFile,
+			Usage:       "Path to public ssh key file when --user-data is not provided.",
+			Destination: &vmAuthorizedKey,
+			EnvVar:      "QVSCLI_META_DATA_FILE",
+		},
+		cli.BoolFlag{
+			Name:        "no-local-login",
+			Usage:       "Disable local login, a password will not be generated and only SSH can be used to access the VM.",
+			Destination: &vmNoLocalLogin,
+			EnvVar:      "QVSCLI_NO_LOCAL_LOGIN",
+		},
+		cli.BoolFlag{
+			Name:        "no-cloud-init",
+			Usage:       "Disable cloud-init metadata ISO creation",
+			Destination: &noCloudInit,
+			EnvVar:      "QVSCLI_NO_CLOUD_INIT",
+		},
+		cli.BoolFlag{
+			Name:        "template",
+			Usage:       "Render --user-data, --meta-data and --network-config as Go templates, implied by --set, --values and --include. Otherwise they are used as is",
+			Destination: &seedTemplate,
+			EnvVar:      "QVSCLI_TEMPLATE",
+		},
+		cli.StringSliceFlag{
+			Name:  "set",
+			Usage: "Set a template value available as .Values.<key>, repeatable, overrides --values. Dotted keys set nested values. Format: key=value",
+		},
+		cli.StringFlag{
+			Name:        "values",
+			Value:       "",
+			Usage:       "Path to a YAML file with template values, available as .Values",
+			Destination: &templateValuesFile,
+			EnvVar:      "QVSCLI_VALUES_FILE",
+		},
+		cli.StringSliceFlag{
+			Name:  "include",
+			Usage: "Path to a template partial, repeatable. Render it with {{ include \"<file name>\" . }}, templates it defines can be included by their name",
+		},
+		cli.StringSliceFlag{
+			Name:  "ssh-import-id",
+			Usage: "Import SSH keys for the default user with ssh-import-id, repeatable. Format: gh:<user> or lp:<user>",
+		},
+		cli.StringSliceFlag{
+			Name:  "add-user",
+			Usage: "Create an additional user, repeatable. List values, and the commands of a sudo rule, are separated by ';'. Format: name=alice[,groups=sudo;docker][,sudo=ALL=(ALL) NOPASSWD:/usr/bin/apt;/usr/bin/systemctl][,shell=/bin/bash][,keys=~/.ssh/a.pub;~/.ssh/b.pub][,ssh-import-id=gh:alice]",
+		},
+		cli.StringFlag{
+			Name:        "users-file",
+			Value:       "",
+			Usage:       "Path to a JSON list of additional users with the fields name, groups, sudo, shell, ssh_authorized_keys and ssh_import_id",
+			Destination: &vmUsersFile,
+			EnvVar:      "QVSCLI_USERS_FILE",
+		},
+		cli.BoolFlag{
+			Name:        "print-secrets",
+			Usage:       "Log generated passwords, by default they are only saved to the secrets store",
+			Destination: &printSecrets,
+			EnvVar:      "QVSCLI_PRINT_SECRETS",
+		},
+	}
+
+	hardwareFlags := []cli.Flag{
+		cli.StringFlag{
+			Name:        "firmware",
+			Usage:       "VM firmware: " + strings.Join(firmwareTypes, ", "),
+			Destination: &hwFirmware,
+		},
+		cli.BoolFlag{
+			Name:        "secure-boot",
+			Usage:       "Enable UEFI Secure Boot, requires --firmware uefi",
+			Destination: &hwSecureBoot,
+		},
+		cli.StringFlag{
+			Name:        "machine",
+			Usage:       "QEMU machine type, for example pc or q35",
+			Destination: &hwMachine,
+		},
+		cli.StringFlag{
+			Name:        "cpu-model",
+			Usage:       "CPU model: host-passthrough, host-model or a QEMU CPU model name such as Haswell",
+			Destination: &hwCPUModel,
+		},
+		cli.IntFlag{
+			Name:        "sockets",
+			Usage:       "Number of CPU sockets, the cores are split evenly across sockets",
+			Destination: &hwSockets,
+		},
+		cli.IntFlag{
+			Name:        "threads",
+			Usage:       "Number of threads per CPU core",
+			Destination: &hwThreads,
+		},
+		cli.StringFlag{
+			Name:        "boot-order",
+			Usage:       "Comma separated boot devices in order, for example cdrom,disk or disk0,disk1. Devices not listed are not bootable",
+			Destination: &hwBootOrder,
+		},
+		cli.BoolFlag{
+			Name:        "autostart",
+			Usage:       "Start the VM when the NAS boots",
+			Destination: &hwAutostart,
+		},
+	}
+
+	waitFlags := []cli.Flag{
+		cli.BoolFlag{
+			Name:        "wait",
+			Usage:       "Wait for the VM to reach its final power state",
+			Destination: &vmWait,
+		},
+		cli.DurationFlag{
+			Name:        "timeout",
+			Value:       5 * time.Minute,
+			Usage:       "Time to wait with --wait",
+			Destination: &vmWaitTimeout,
+		},
+	}
+
+	sshFlags := []cli.Flag{
+		cli.StringFlag{
+			Name:        "user, u",
+			Value:       defaultSSHUser,
+			Usage:       "User to log in as",
+			Destination: &sshUser,
+			EnvVar:      "QVSCLI_SSH_USER",
+		},
+		cli.StringFlag{
+			Name:        "authorized-key",
+			Value:       defaultPubKeyFile,
+			Usage:       "Public ssh key the VM was created with, its private half is used unless a key was generated for the VM",
+			Destination: &vmAuthorizedKey,
+		},
+		cli.BoolFlag{
+			Name:        "wait",
+			Usage:       "Wait until the VM has an IP, sshd answers and cloud-init has finished",
+			Destination: &vmWait,
+		},
+		cli.DurationFlag{
+			Name:        "timeout",
+			Value:       10 * time.Minute,
+			Usage:       "Time to wait with --wait",
+			Destination: &vmWaitTimeout,
+		},
+	}
+
+	keyFlags := []cli.Flag{
+		cli.DurationFlag{
+			Name:        "delay",
+			Value:       50 * time.Millisecond,
+			Usage:       "Pause after each key combination",
+			Destination: &keyDelay,
+		},
+		cli.StringFlag{
+			Name:        "vnc-password",
+			Value:       "",
+			Usage:       "VNC password of the VM. Default is the password in the secrets store",
+			Destination: &vmVNCPassword,
+			EnvVar:      "QVSCLI_VM_VNC_PASSWORD",
+		},
+		cli.BoolFlag{
+			Name:        "websocket",
+			Usage:       "Connect through the QVS web console on the QTS port instead of the VNC port of the NAS",
+			Destination: &consoleWebsocket,
+		},
+	}
+
 	app := cli.NewApp()
 	app.Name = "qvscli"
 	app.Usage = "Interact with QNAP Virtualization Station"
@@ -77,10 +395,17 @@ func main() {
 			Destination: &qvsDisksDir,
 			EnvVar:      "QVSCLI_QVS_DISKS_DIR",
 		},
+		cli.StringFlag{
+			Name:        "ipam-file",
+			Value:       "",
+			Usage:       "NAS path to the IP pools and leases used by --ip auto. Default is qvscli_ipam.json in the qvs-disks-dir",
+			Destination: &ipamFile,
+			EnvVar:      "QVSCLI_IPAM_FILE",
+		},
 		cli.StringFlag{
 			Name:        "qvs-images-dir",
 			Value:       "/VirtualMachines/images",
-			Usage:       "NAS path to base image directory containing folders or .img files",
+			Usage:       "NAS path to base image directory containing folders or .img, .qcow2 and .raw files",
 			Destination: &qvsImagesDir,
 			EnvVar:      "QVSCLI_QVS_IMAGES_DIR",
 		},
@@ -91,6 +416,13 @@ func main() {
 			Destination: &loginFile,
 			EnvVar:      "QVSCLI_LOGIN_FILE",
 		},
+		cli.StringFlag{
+			Name:        "secrets-file",
+			Value:       defaultSecretsFile,
+			Usage:       "Local store of credentials generated for VMs",
+			Destination: &secretsFile,
+			EnvVar:      "QVSCLI_SECRETS_FILE",
+		},
 		cli.BoolFlag{
 			Name:        "debug",
 			Usage:       "Enable HTTP response debugging",
@@ -130,6 +462,46 @@ func main() {
 				},
 			},
 		},
+		{
+			Name:  "secrets",
+			Usage: "options for credentials generated by qvscli, kept encrypted in the secrets store",
+			Subcommands: []cli.Command{
+				{
+					Name:      "get",
+					Usage:     "print the stored credentials of a VM, or a single field of them",
+					ArgsUsage: "[vm] [field]",
+					Action: func(c *cli.Context) error {
+						client := getClient()
+						vm, err := client.VMGet(c.Args().First())
+						if err != nil {
+							return err
+						}
+						secrets := getSecrets()
+						fields := secrets.Fields(vm.UUID)
+						if len(fields) == 0 {
+							return fmt.Errorf("no credentials of VM %s in the secrets store %s", vm.Name, secretsFile)
+						}
+						if field := c.Args().Get(1); field != "" {
+							value := secrets.Get(vm.UUID, field)
+							if value == "" {
+								return fmt.Errorf("no field %s for VM %s, stored fields: %s", field, vm.Name, strings.Join(fields, ", "))
+							}
+							fmt.Println(strings.TrimSuffix(value, "\n"))
+							return nil
+						}
+						for _, f := range fields {
+							value := strings.TrimSuffix(secrets.Get(vm.UUID, f), "\n")
+							if strings.Contains(value, "\n") {
+								fmt.Printf("%s:\n%s\n", f, value)
+							} else {
+								fmt.Printf("%s: %s\n", f, value)
+							}
+						}
+						return nil
+					},
+				},
+			},
+		},
 		{
 			Name:    "images",
 			Aliases: []string{"image"},
@@ -172,15 +544,15 @@ func main() {
 							w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', 0)
 							fmt.Fprintln(w, "NAME")
 							for _, f := range imageFiles {
-								// Filter by *.img, folders
-								if (strings.Contains(f.Filename, ".img") || f.IsFolder == 1) && !strings.Contains(f.Filename, "@") {
+								// Filter by image files, folders
+								if (isImageFile(f.Filename) || f.IsFolder == 1) && !strings.Contains(f.Filename, "@") {
 									displayName := filepath.Join(imageFilesPath, f.Filename)
 									if f.IsFolder == 1 {
 										displayName += "/"
 									}
-									fmt.Fprintf(w, strings.Join([]string{
+									fmt.Fprintln(w, strings.Join([]string{
 										displayName,
-									}, "\t")+"\n")
+									}, "\t"))
 								}
 							}
 							w.Flush()
@@ -190,6 +562,90 @@ func main() {
 						return nil
 					},
 				},
+				{
+					Name:      "delete",
+					Aliases:   []string{"del", "rm"},
+					Usage:     "delete an image from the qvs-images-dir, refused while linked VM disks use it",
+					ArgsUsage: "[path]",
+					Action: func(c *cli.Context) error {
+						client := getClient()
+
+						imageFilePath := c.Args().First()
+						if imageFilePath == "" {
+							return fmt.Errorf("no image path provided")
+						}
+						imagePath := filepath.Join(qvsImagesDir, imageFilePath)
+
+						if err := checkImageUnused(client, imagePath); err != nil {
+							return err
+						}
+
+						meta, err := client.ImageMetadataGet(imagePath)
+						if err != nil {
+							return err
+						}
+
+						if err := client.DeleteFile(imagePath); err != nil {
+							return err
+						}
+						log.Printf("INFO: Deleted image: %s", imagePath)
+
+						if meta != nil {
+							if err := client.DeleteFile(imagePath + imageMetadataSuffix); err != nil {
+								return err
+							}
+						}
+						return nil
+					},
+				},
+			},
+		},
+		{
+			Name:    "os-types",
+			Aliases: []string{"os"},
+			Usage:   "options for VM operating system types",
+			Subcommands: []cli.Command{
+				{
+					Name:    "list",
+					Aliases: []string{"ls"},
+					Usage:   "list the catalog of QVS OS types and their defaults",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:        "output, o",
+							Usage:       "Output format, text or json",
+							Value:       "text",
+							Destination: &outputFormat,
+						},
+					},
+					Action: func(c *cli.Context) error {
+						if outputFormat == "json" {
+							pretty, _ := json.MarshalIndent(osTypes, "", "  ")
+							fmt.Println(string(pretty))
+						} else if outputFormat == "text" {
+							w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', 0)
+							fmt.Fprintln(w, "ID\tNAME\tDISK BUS\tNIC MODEL\tCLOUD-INIT")
+							for _, o := range osTypes {
+								fmt.Fprintln(w, strings.Join([]string{
+									o.ID,
+									o.Name,
+									o.DiskBus,
+									o.NICModel,
+									fmt.Sprintf("%t", o.CloudInit),
+								}, "\t"))
+							}
+							w.Flush()
+							var aliases []string
+							for alias, id := range osTypeAliases {
+								aliases = append(aliases, fmt.Sprintf("%s=%s", alias, id))
+							}
+							sort.Strings(aliases)
+							fmt.Printf("\nAliases: %s\n", strings.Join(aliases, ", "))
+						} else {
+							return fmt.Errorf("invalid output format: %s", outputFormat)
+						}
+						return nil
+					},
+				},
 			},
 		},
 		{
@@ -229,12 +685,12 @@ func main() {
 							w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', 0)
 							fmt.Fprintln(w, "NAME\tBRIDGE\tIP\tINTERFACES")
 							for _, n := range networks {
-								fmt.Fprintf(w, strings.Join([]string{
+								fmt.Fprintln(w, strings.Join([]string{
 									n.DisplayName,
 									n.Name,
 									n.IP,
 									strings.Join(n.NICs, ","),
-								}, "\t")+"\n")
+								}, "\t"))
 							}
 							w.Flush()
 						} else {
@@ -244,6 +700,179 @@ func main() {
 						return nil
 					},
 				},
+				{
+					Name:  "leases",
+					Usage: "list the IPs allocated to VMs from the IP pools",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:        "output, o",
+							Usage:       "Output format, text or json",
+							Value:       "text",
+							Destination: &outputFormat,
+						},
+					},
+					Action: func(c *cli.Context) error {
+						client := getClient()
+						ipam, err := client.IPAMLoad(getIPAMFile())
+						if err != nil {
+							return err
+						}
+						leases := ipam.Leases
+						sort.Slice(leases, func(i, j int) bool {
+							if leases[i].Network != leases[j].Network {
+								return leases[i].Network < leases[j].Network
+							}
+							return bytes.Compare(net.ParseIP(leases[i].IP), net.ParseIP(leases[j].IP)) < 0
+						})
+
+						if outputFormat == "json" {
+							pretty, _ := json.MarshalIndent(leases, "", "  ")
+							fmt.Println(string(pretty))
+						} else if outputFormat == "text" {
+							w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', 0)
+							fmt.Fprintln(w, "NETWORK\tIP\tMAC\tVM\tUUID\tCREATED")
+							for _, l := range leases {
+								fmt.Fprintln(w, strings.Join([]string{
+									l.Network,
+									l.IP,
+									l.MAC,
+									l.VMName,
+									l.VMUUID,
+									l.Created.Format(time.RFC3339),
+								}, "\t"))
+							}
+							w.Flush()
+						} else {
+							return fmt.Errorf("invalid output format: %s", outputFormat)
+						}
+						return nil
+					},
+				},
+				{
+					Name:  "pool",
+					Usage: "options for the IP pools used by 'vm create --ip auto'",
+					Subcommands: []cli.Command{
+						{
+							Name:      "set",
+							Usage:     "create or update the IP pool of a network",
+							ArgsUsage: "[network]",
+							Flags: []cli.Flag{
+								cli.StringFlag{
+									Name:        "cidr",
+									Usage:       "Subnet of the network, for example 10.0.0.0/24",
+									Destination: &poolCIDR,
+								},
+								cli.StringFlag{
+									Name:        "range",
+									Usage:       "Addresses handed out to VMs, for example 10.0.0.100-10.0.0.199",
+									Destination: &poolRange,
+								},
+								cli.StringFlag{
+									Name:        "gateway",
+									Usage:       "Default gateway of the VMs",
+									Destination: &poolGateway,
+								},
+								cli.StringSliceFlag{
+									Name:  "dns",
+									Usage: "DNS server of the VMs, repeatable",
+								},
+								cli.StringSliceFlag{
+									Name:  "search",
+									Usage: "DNS search domain of the VMs, repeatable",
+								},
+							},
+							Action: func(c *cli.Context) error {
+								client := getClient()
+								network, err := client.QVSNetName(c.Args().First())
+								if err != nil {
+									return err
+								}
+								if poolCIDR == "" || poolRange == "" {
+									return fmt.Errorf("--cidr and --range are required")
+								}
+								start, end, err := parseIPRange(poolRange)
+								if err != nil {
+									return err
+								}
+								pool := IPAMPool{
+									Network:    network,
+									CIDR:       poolCIDR,
+									RangeStart: start,
+									RangeEnd:   end,
+									Gateway:    poolGateway,
+									DNS:        c.StringSlice("dns"),
+									Search:     c.StringSlice("search"),
+								}
+								if err := pool.validate(); err != nil {
+									return err
+								}
+								err = client.IPAMUpdate(getIPAMFile(), func(ipam *IPAM) error {
+									ipam.SetPool(pool)
+									return nil
+								})
+								if err != nil {
+									return err
+								}
+								log.Printf("INFO: IP pool of network %s set to %s - %s", network, start, end)
+								return nil
+							},
+						},
+						{
+							Name:    "list",
+							Aliases: []string{"ls"},
+							Usage:   "list the IP pools",
+							Action: func(c *cli.Context) error {
+								client := getClient()
+								ipam, err := client.IPAMLoad(getIPAMFile())
+								if err != nil {
+									return err
+								}
+								w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', 0)
+								fmt.Fprintln(w, "NETWORK\tCIDR\tRANGE\tGATEWAY\tDNS\tSEARCH\tLEASES")
+								for _, p := range ipam.Pools {
+									leases := 0
+									for _, l := range ipam.Leases {
+										if l.Network == p.Network {
+											leases++
+										}
+									}
+									fmt.Fprintln(w, strings.Join([]string{
+										p.Network,
+										p.CIDR,
+										p.RangeStart + "-" + p.RangeEnd,
+										p.Gateway,
+										strings.Join(p.DNS, ","),
+										strings.Join(p.Search, ","),
+										fmt.Sprintf("%d", leases),
+									}, "\t"))
+								}
+								w.Flush()
+								return nil
+							},
+						},
+						{
+							Name:      "delete",
+							Aliases:   []string{"rm"},
+							Usage:     "delete the IP pool of a network, it must have no leases",
+							ArgsUsage: "[network]",
+							Action: func(c *cli.Context) error {
+								client := getClient()
+								network, err := client.QVSNetName(c.Args().First())
+								if err != nil {
+									return err
+								}
+								err = client.IPAMUpdate(getIPAMFile(), func(ipam *IPAM) error {
+									return ipam.DeletePool(network)
+								})
+								if err != nil {
+									return err
+								}
+								log.Printf("INFO: Deleted IP pool of network %s", network)
+								return nil
+							},
+						},
+					},
+				},
 			},
 		},
 		{
@@ -268,6 +897,18 @@ func main() {
 						if err != nil {
 							return err
 						}
+						var neighbors []NetMgrNeighbor
+						for _, v := range vms {
+							if v.PowerState == QVSPowerStateRunning {
+								if neighbors, err = client.NetMgrNeighbors(); err != nil {
+									log.Printf("WARN: could not read DHCP leases and ARP table of the NAS: %v", err)
+								}
+								break
+							}
+						}
+						for i := range vms {
+							vms[i].IPs = client.VMIPs(vms[i], neighbors)
+						}
 						if outputFormat == "json" {
 							pretty, _ := json.MarshalIndent(vms, "", "  ")
 							fmt.Println(string(pretty))
@@ -278,20 +919,26 @@ func main() {
 							})
 
 							w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', 0)
-							fmt.Fprintln(w, "NAME\tID\tSTATE\tNETWORK\tMAC ADDRESS\tVNC PORT")
+							fmt.Fprintln(w, "NAME\tID\tSTATE\tNETWORK\tMAC ADDRESS\tIP\tVNC PORT")
 							for _, v := range vms {
 								vncPort := ""
 								if len(v.Graphics) > 0 && v.Graphics[0].Port > 0 {
 									vncPort = fmt.Sprintf("%d", v.Graphics[0].Port)
 								}
-								fmt.Fprintf(w, strings.Join([]string{
+								var bridges, macs []string
+								for _, a := range v.Adapters {
+									bridges = append(bridges, a.Bridge)
+									macs = append(macs, a.MAC)
+								}
+								fmt.Fprintln(w, strings.Join([]string{
 									v.Name,
 									fmt.Sprintf("%d", v.ID),
 									v.PowerState,
-									v.Adapters[0].Bridge,
-									v.Adapters[0].MAC,
+									strings.Join(bridges, ","),
+									strings.Join(macs, ","),
+									strings.Join(v.IPs, ","),
 									vncPort,
-								}, "\t")+"\n")
+								}, "\t"))
 							}
 							w.Flush()
 						} else {
@@ -307,85 +954,537 @@ func main() {
 					Action: func(c *cli.Context) error {
 						client := getClient()
 						idOrName := c.Args().First()
-						id, err := client.VMGetID(idOrName)
+						vm, err := client.VMGet(idOrName)
 						if err != nil {
 							return err
 						}
-						vms, err := client.VMDescribe(id)
+						vms, err := client.VMDescribe(fmt.Sprintf("%d", vm.ID))
 						if err != nil {
 							return err
 						}
+						if data, ok := vms.(map[string]interface{}); ok && vm.PowerState == QVSPowerStateRunning {
+							neighbors, _ := client.NetMgrNeighbors()
+							data["ips"] = client.VMIPs(vm, neighbors)
+						}
 						pretty, _ := json.MarshalIndent(vms, "", "  ")
 						fmt.Println(string(pretty))
 						return nil
 					},
 				},
 				{
-					Name:  "start",
-					Usage: "start a stopped VM by ID or name",
+					Name:      "ip",
+					Usage:     "print the IP addresses of a running VM, from the guest agent or the DHCP leases and ARP table of the NAS",
+					ArgsUsage: "[vm]",
+					Flags: []cli.Flag{
+						cli.BoolFlag{
+							Name:        "wait",
+							Usage:       "Wait until the VM has an IP address",
+							Destination: &vmWait,
+						},
+						cli.DurationFlag{
+							Name:        "timeout",
+							Value:       5 * time.Minute,
+							Usage:       "Time to wait with --wait",
+							Destination: &vmWaitTimeout,
+						},
+					},
 					Action: func(c *cli.Context) error {
 						client := getClient()
-						idOrName := c.Args().First()
-						id, err := client.VMGetID(idOrName)
+						vm, err := client.VMGet(c.Args().First())
 						if err != nil {
 							return err
 						}
-						if err := client.VMStart(id); err != nil {
-							return err
+						var ips []string
+						if vmWait {
+							if vm.PowerState != QVSPowerStateRunning {
+								return fmt.Errorf("error, VM %s is not running, current state: %s", vm.Name, vm.PowerState)
+							}
+							if ips, err = client.VMWaitIP(vm.Name, vmWaitTimeout); err != nil {
+								return err
+							}
 						} else {
-							log.Printf("INFO: started VM: %s", idOrName)
+							neighbors, err := client.NetMgrNeighbors()
+							if err != nil {
+								log.Printf("WARN: could not read DHCP leases and ARP table of the NAS: %v", err)
+							}
+							ips = client.VMIPs(vm, neighbors)
+						}
+						if len(ips) == 0 {
+							return fmt.Errorf("no IP address found for VM %s, is it running?", vm.Name)
+						}
+						for _, ip := range ips {
+							fmt.Println(ip)
 						}
 						return nil
 					},
 				},
 				{
-					Name:  "reset",
-					Usage: "reset a VM by ID or name",
+					Name:      "ssh",
+					Usage:     "log into a VM with ssh, or run a command on it",
+					ArgsUsage: "[vm] [-- command]",
+					Flags:     sshFlags,
 					Action: func(c *cli.Context) error {
 						client := getClient()
-						idOrName := c.Args().First()
-						id, err := client.VMGetID(idOrName)
+						t, err := client.VMSSHTarget(c.Args().First(), sshUser, getSecrets(), vmAuthorizedKey, vmWait, vmWaitTimeout)
+						if err != nil {
+							return err
+						}
+						defer t.Close()
+						return runAttached(t.Command(c.Args().Tail()...))
+					},
+				},
+				{
+					Name:      "scp",
+					Usage:     "copy files to or from a VM with scp, use <vm>:<path> for remote paths",
+					ArgsUsage: "[source]... [destination]",
+					Flags: append([]cli.Flag{
+						cli.BoolFlag{
+							Name:        "recursive, r",
+							Usage:       "Copy directories recursively",
+							Destination: &scpRecursive,
+						},
+					}, sshFlags...),
+					Action: func(c *cli.Context) error {
+						client := getClient()
+						if len(c.Args()) < 2 {
+							return fmt.Errorf("scp needs a source and a destination")
+						}
+						name, err := scpVMName(c.Args())
 						if err != nil {
 							return err
 						}
-						if err := client.VMReset(id); err != nil {
+						t, err := client.VMSSHTarget(name, sshUser, getSecrets(), vmAuthorizedKey, vmWait, vmWaitTimeout)
+						if err != nil {
 							return err
-						} else {
-							log.Printf("INFO: reset VM: %s", idOrName)
 						}
-						return nil
+						defer t.Close()
+						return runAttached(t.SCPCommand(name, scpRecursive, c.Args()...))
 					},
 				},
 				{
-					Name:    "stop",
-					Aliases: []string{"shutdown"},
-					Usage:   "stop a VM by ID or name",
+					Name:      "console",
+					Usage:     "open a local VNC proxy to the console of a running VM, the VNC password is sent by the proxy",
+					ArgsUsage: "[vm]",
 					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:        "listen",
+							Value:       "127.0.0.1:0",
+							Usage:       "Local address to listen on for VNC viewers, a random port is used by default",
+							Destination: &consoleListen,
+						},
+						cli.StringFlag{
+							Name:        "vnc-password",
+							Value:       "",
+							Usage:       "VNC password of the VM. Default is the password in the secrets store",
+							Destination: &vmVNCPassword,
+							EnvVar:      "QVSCLI_VM_VNC_PASSWORD",
+						},
 						cli.BoolFlag{
-							Name:        "force",
-							Usage:       "force shutdown the VM",
-							Destination: &vmForceShutdown,
+							Name:        "websocket",
+							Usage:       "Tunnel through the QVS web console on the QTS port instead of connecting to the VNC port of the NAS directly",
+							Destination: &consoleWebsocket,
+						},
+						cli.BoolFlag{
+							Name:        "open",
+							Usage:       "Launch a VNC viewer connected to the proxy",
+							Destination: &consoleOpen,
+						},
+						cli.StringFlag{
+							Name:        "viewer",
+							Value:       "",
+							Usage:       "VNC viewer command to launch with --open, called with host::port",
+							Destination: &consoleViewer,
+							EnvVar:      "QVSCLI_VNC_VIEWER",
+						},
+						cli.BoolFlag{
+							Name:        "print-url",
+							Usage:       "Print the URL of the web console and exit",
+							Destination: &consolePrintURL,
 						},
 					},
 					Action: func(c *cli.Context) error {
 						client := getClient()
-						idOrName := c.Args().First()
-						id, err := client.VMGetID(idOrName)
+						vm, err := client.VMGet(c.Args().First())
+						if err != nil {
+							return err
+						}
+						if consolePrintURL {
+							fmt.Println(client.VMConsoleURL(fmt.Sprintf("%d", vm.ID)))
+							return nil
+						}
+						password := vncPassword(vm)
+						if len(vm.Graphics) > 0 && vm.Graphics[0].EnablePassword && password == "" {
+							return fmt.Errorf("VM %s has a VNC password that is not in the secrets store, pass it with --vnc-password", vm.Name)
+						}
+
+						// Check the console is reachable before listening.
+						dial := func() (net.Conn, error) {
+							v, err := client.VMGet(fmt.Sprintf("%d", vm.ID))
+							if err != nil {
+								return nil, err
+							}
+							return client.VMVNCDial(v, consoleWebsocket)
+						}
+						conn, err := dial()
 						if err != nil {
 							return err
 						}
-						if err := client.VMShutdown(id, vmForceShutdown); err != nil {
+						err = rfbClientHandshake(conn, password)
+						conn.Close()
+						if err != nil {
 							return err
-						} else {
-							if vmForceShutdown {
-								log.Printf("INFO: VM stopped: %s.", idOrName)
-							} else {
-								log.Printf("INFO: Sent ACPI shutdown signal to VM: %s.", idOrName)
+						}
+
+						l, err := net.Listen("tcp", consoleListen)
+						if err != nil {
+							return err
+						}
+						defer l.Close()
+						log.Printf("INFO: VNC console of %s available on %s, press Ctrl-C to stop", vm.Name, l.Addr())
+						if consoleOpen {
+							if err := openVNCViewer(consoleViewer, l.Addr().String()); err != nil {
+								return fmt.Errorf("error launching VNC viewer: %v", err)
+							}
+						}
+						return serveVNCProxy(l, dial, password)
+					},
+				},
+				{
+					Name:      "screenshot",
+					Usage:     "save a PNG screenshot of the console of a running VM",
+					ArgsUsage: "[vm]",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:        "output, o",
+							Value:       "",
+							Usage:       "PNG file to write. Default is <vm>.png",
+							Destination: &screenshotFile,
+						},
+						cli.StringFlag{
+							Name:        "vnc-password",
+							Value:       "",
+							Usage:       "VNC password of the VM. Default is the password in the secrets store",
+							Destination: &vmVNCPassword,
+							EnvVar:      "QVSCLI_VM_VNC_PASSWORD",
+						},
+						cli.BoolFlag{
+							Name:        "websocket",
+							Usage:       "Connect through the QVS web console on the QTS port instead of the VNC port of the NAS",
+							Destination: &consoleWebsocket,
+						},
+					},
+					Action: func(c *cli.Context) error {
+						client := getClient()
+						vm, err := client.VMGet(c.Args().First())
+						if err != nil {
+							return err
+						}
+						rfb, err := client.VMRFBClient(vm, consoleWebsocket, vncPassword(vm))
+						if err != nil {
+							return err
+						}
+						defer rfb.Close()
+						img, err := rfb.Screenshot()
+						if err != nil {
+							return err
+						}
+						if screenshotFile == "" {
+							screenshotFile = vm.Name + ".png"
+						}
+						f, err := os.Create(screenshotFile)
+						if err != nil {
+							return err
+						}
+						if err := png.Encode(f, img); err != nil {
+							f.Close()
+							return err
+						}
+						if err := f.Close(); err != nil {
+							return err
+						}
+						log.Printf("INFO: Saved %dx%d screenshot of %s to %s", rfb.Width, rfb.Height, vm.Name, screenshotFile)
+						return nil
+					},
+				},
+				{
+					Name:      "serial",
+					Usage:     "attach the terminal to the serial console of a running VM, press Ctrl-] to detach",
+					ArgsUsage: "[vm]",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:        "log",
+							Value:       "",
+							Usage:       "Append console output to this file",
+							Destination: &serialLog,
+						},
+						cli.BoolFlag{
+							Name:        "websocket",
+							Usage:       "Use the QVS console websocket even if the VM has a serial-over-telnet device",
+							Destination: &consoleWebsocket,
+						},
+					},
+					Action: func(c *cli.Context) error {
+						client := getClient()
+						vm, err := client.VMGet(c.Args().First())
+						if err != nil {
+							return err
+						}
+						var logw io.Writer
+						if serialLog != "" {
+							f, err := os.OpenFile(serialLog, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+							if err != nil {
+								return err
 							}
+							defer f.Close()
+							logw = f
+						}
+						conn, err := client.VMSerialDial(vm, consoleWebsocket)
+						if err != nil {
+							return err
+						}
+						fmt.Fprintf(os.Stderr, "Connected to the serial console of %s, press Ctrl-] to detach.\r\n", vm.Name)
+						if err := attachSerial(conn, logw); err != nil {
+							return err
 						}
+						fmt.Fprintf(os.Stderr, "\r\nDetached from %s.\n", vm.Name)
 						return nil
 					},
 				},
+				{
+					Name:  "vnc",
+					Usage: "manage the VNC password of a VM",
+					Subcommands: []cli.Command{
+						{
+							Name:      "show",
+							Usage:     "print the VNC port and password of a VM",
+							ArgsUsage: "[vm]",
+							Action: func(c *cli.Context) error {
+								client := getClient()
+								vm, err := client.VMGet(c.Args().First())
+								if err != nil {
+									return err
+								}
+								if len(vm.Graphics) == 0 {
+									return fmt.Errorf("VM %s has no VNC console", vm.Name)
+								}
+								if vm.Graphics[0].Port > 0 {
+									fmt.Printf("Port: %d\n", vm.Graphics[0].Port)
+								}
+								if !vm.Graphics[0].EnablePassword {
+									fmt.Println("Password: disabled")
+									return nil
+								}
+								password := getSecrets().Get(vm.UUID, secretVNCPassword)
+								if password == "" {
+									return fmt.Errorf("VNC password of %s is not in the secrets store, set a new one with 'qvscli vm vnc rotate %s'", vm.Name, vm.Name)
+								}
+								fmt.Printf("Password: %s\n", password)
+								return nil
+							},
+						},
+						{
+							Name:      "rotate",
+							Usage:     "set a new VNC password on a VM, generated unless --vnc-password is given",
+							ArgsUsage: "[vm]",
+							Flags: []cli.Flag{
+								cli.StringFlag{
+									Name:        "vnc-password",
+									Value:       "",
+									Usage:       "New VNC password up to 8 characters long",
+									Destination: &vmVNCPassword,
+								},
+							},
+							Action: func(c *cli.Context) error {
+								client := getClient()
+								vm, err := client.VMGet(c.Args().First())
+								if err != nil {
+									return err
+								}
+								if len(vm.Graphics) == 0 {
+									return fmt.Errorf("VM %s has no VNC console", vm.Name)
+								}
+								newPassword := vmVNCPassword
+								if newPassword == "" {
+									if newPassword, err = password.Generate(8, 2, 0, false, false); err != nil {
+										return err
+									}
+								}
+								if err := validateVNCPassword(newPassword); err != nil {
+									return err
+								}
+								secrets := getSecrets()
+								if err := secrets.Unlock(); err != nil {
+									return err
+								}
+								if err := client.VMGraphicsUpdate(fmt.Sprintf("%d", vm.ID), vm.Graphics[0].ID, newPassword); err != nil {
+									return err
+								}
+								secrets.Set(vm.UUID, secretVNCPassword, newPassword)
+								if err := secrets.Save(); err != nil {
+									return err
+								}
+								log.Printf("INFO: VNC password of %s changed and saved to %s", vm.Name, secretsFile)
+								if vm.PowerState != QVSPowerStateStop {
+									log.Printf("INFO: Restart the VM if the running console still uses the old password.")
+								}
+								return nil
+							},
+						},
+						{
+							Name:      "disable",
+							Usage:     "remove the VNC password of a VM",
+							ArgsUsage: "[vm]",
+							Action: func(c *cli.Context) error {
+								client := getClient()
+								vm, err := client.VMGet(c.Args().First())
+								if err != nil {
+									return err
+								}
+								if len(vm.Graphics) == 0 {
+									return fmt.Errorf("VM %s has no VNC console", vm.Name)
+								}
+								if err := client.VMGraphicsUpdate(fmt.Sprintf("%d", vm.ID), vm.Graphics[0].ID, ""); err != nil {
+									return err
+								}
+								secrets := getSecrets()
+								if stringInSlice(secretVNCPassword, secrets.Fields(vm.UUID)) {
+									secrets.Unset(vm.UUID, secretVNCPassword)
+									if err := secrets.Save(); err != nil {
+										return err
+									}
+								}
+								log.Printf("WARN: VNC password of %s disabled, anyone who can reach port %d on the NAS can use the console", vm.Name, vm.Graphics[0].Port)
+								return nil
+							},
+						},
+					},
+				},
+				{
+					Name:      "sendkeys",
+					Usage:     "send key combinations to the console of a running VM, for example: ctrl-alt-del, f2, down, enter",
+					ArgsUsage: "[vm] [keys]...",
+					Flags:     keyFlags,
+					Action: func(c *cli.Context) error {
+						client := getClient()
+						if len(c.Args()) < 2 {
+							return fmt.Errorf("no keys provided")
+						}
+						var combos [][]uint32
+						for _, arg := range c.Args().Tail() {
+							keys, err := parseKeyCombo(arg)
+							if err != nil {
+								return err
+							}
+							combos = append(combos, keys)
+						}
+						vm, err := client.VMGet(c.Args().First())
+						if err != nil {
+							return err
+						}
+						rfb, err := client.VMRFBClient(vm, consoleWebsocket, vncPassword(vm))
+						if err != nil {
+							return err
+						}
+						defer rfb.Close()
+						return rfb.SendKeys(combos, keyDelay)
+					},
+				},
+				{
+					Name:      "type",
+					Usage:     "type text on the console of a running VM, newlines press enter",
+					ArgsUsage: "[vm]",
+					Flags: append([]cli.Flag{
+						cli.StringFlag{
+							Name:        "text",
+							Usage:       "Text to type",
+							Destination: &typeText,
+						},
+					}, keyFlags...),
+					Action: func(c *cli.Context) error {
+						client := getClient()
+						if typeText == "" {
+							return fmt.Errorf("no --text provided")
+						}
+						combos, err := textKeys(typeText)
+						if err != nil {
+							return err
+						}
+						vm, err := client.VMGet(c.Args().First())
+						if err != nil {
+							return err
+						}
+						rfb, err := client.VMRFBClient(vm, consoleWebsocket, vncPassword(vm))
+						if err != nil {
+							return err
+						}
+						defer rfb.Close()
+						return rfb.SendKeys(combos, keyDelay)
+					},
+				},
+				{
+					Name:      "start",
+					Usage:     "start a stopped VM by ID or name",
+					ArgsUsage: "[vm]",
+					Flags:     waitFlags,
+					Action: func(c *cli.Context) error {
+						return vmPower(c.Args().First(), "start")
+					},
+				},
+				{
+					Name:      "reset",
+					Usage:     "hard reset a running VM by ID or name",
+					ArgsUsage: "[vm]",
+					Action: func(c *cli.Context) error {
+						return vmPower(c.Args().First(), "reset")
+					},
+				},
+				{
+					Name:      "reboot",
+					Aliases:   []string{"restart"},
+					Usage:     "gracefully reboot a running VM by ID or name with an ACPI signal",
+					ArgsUsage: "[vm]",
+					Action: func(c *cli.Context) error {
+						return vmPower(c.Args().First(), "reboot")
+					},
+				},
+				{
+					Name:      "stop",
+					Aliases:   []string{"shutdown"},
+					Usage:     "stop a VM by ID or name",
+					ArgsUsage: "[vm]",
+					Flags: append([]cli.Flag{
+						cli.BoolFlag{
+							Name:        "force",
+							Usage:       "force shutdown the VM",
+							Destination: &vmForceShutdown,
+						},
+					}, waitFlags...),
+					Action: func(c *cli.Context) error {
+						if vmForceShutdown {
+							return vmPower(c.Args().First(), "forceshutdown")
+						}
+						return vmPower(c.Args().First(), "shutdown")
+					},
+				},
+				{
+					Name:      "suspend",
+					Aliases:   []string{"pause"},
+					Usage:     "suspend a running VM by ID or name",
+					ArgsUsage: "[vm]",
+					Flags:     waitFlags,
+					Action: func(c *cli.Context) error {
+						return vmPower(c.Args().First(), "suspend")
+					},
+				},
+				{
+					Name:      "resume",
+					Usage:     "resume a suspended VM by ID or name",
+					ArgsUsage: "[vm]",
+					Flags:     waitFlags,
+					Action: func(c *cli.Context) error {
+						return vmPower(c.Args().First(), "resume")
+					},
+				},
 				{
 					Name:    "delete",
 					Aliases: []string{"del", "rm"},
@@ -423,6 +1522,12 @@ func main() {
 							}
 						}
 
+						// Unlock the secrets store before anything is deleted, failing to do so must not stop the cleanup.
+						secrets, err := loadSecrets(secretsFile, promptPassphrase)
+						if err != nil {
+							log.Printf("WARN: failed to open the secrets store, stored credentials of VM %s remain in %s: %v", vm.Name, secretsFile, err)
+						}
+
 						// Make sure VM is stopped
 						if vm.PowerState != "stop" {
 							log.Printf("WARN: forcing shutdown of running vm: %s", idOrName)
@@ -436,17 +1541,55 @@ func main() {
 							return err
 						}
 						log.Printf("INFO: Deleted VM: %s", idOrName)
-
-						// Delete disk dir.
-						if vmNoDiskDel {
+						if secrets != nil && len(secrets.Fields(vm.UUID)) > 0 {
+							secrets.Delete(vm.UUID)
+							if err := secrets.Save(); err != nil {
+								log.Printf("WARN: failed to delete the stored credentials of VM %s: %v", vm.Name, err)
+							} else {
+								log.Printf("INFO: Deleted stored credentials of VM: %s", vm.Name)
+							}
+						}
+						var macs []string
+						for _, a := range vm.Adapters {
+							macs = append(macs, a.MAC)
+						}
+						released, err := client.IPAMRelease(getIPAMFile(), vm.UUID, macs)
+						if err != nil {
+							log.Printf("WARN: failed to release the IPs of VM %s, its leases remain in %s: %v", vm.Name, getIPAMFile(), err)
+						}
+						for _, l := range released {
+							log.Printf("INFO: Released IP %s on network %s", l.IP, l.Network)
+						}
+
+						// Delete disk dir.
+						if vmNoDiskDel {
 							vmDiskPath := filepath.Join(qvsDisksDir, vm.Name)
 							return fmt.Errorf("WARN: skipping disk deletion, disk data remains on NAS: %s", vmDiskPath)
-						} else if len(vm.Disks) > 0 {
-							vmDiskFolder := filepath.Dir(vm.Disks[0].Path)
-							if err := client.DeleteFile(vmDiskFolder); err != nil {
-								return err
+						}
+						others, err := client.VMList()
+						if err != nil {
+							return err
+						}
+						folders, kept, err := vmDiskFolders(qvsDisksDir, vm, others)
+						if err != nil {
+							return err
+						}
+						for _, p := range kept {
+							log.Printf("WARN: %s is not owned by the VM, it remains on NAS", p)
+						}
+						existing, err := client.ListDir(qvsDisksDir)
+						if err != nil {
+							return err
+						}
+						for _, dir := range folders {
+							for _, f := range existing {
+								if f.IsFolder == 1 && f.Filename == filepath.Base(dir) {
+									if err := client.DeleteFile(dir); err != nil {
+										return err
+									}
+									log.Printf("INFO: Deleted VM disk folder: %s", dir)
+								}
 							}
-							log.Printf("INFO: Deleted VM disk folder: %s", vmDiskFolder)
 						}
 						return nil
 					},
@@ -455,52 +1598,114 @@ func main() {
 					Name:    "create",
 					Aliases: []string{"c"},
 					Usage:   "create a VM with provided meta-data and user-data",
-					Flags: []cli.Flag{
+					Flags: append([]cli.Flag{
 						cli.BoolFlag{
 							Name:        "no-start",
 							Usage:       "Do not auto-start VM after creation",
 							Destination: &vmNoStart,
 							EnvVar:      "QVSCLI_VM_NO_START",
 						},
+						cli.BoolFlag{
+							Name:        "eject-seed-after-boot",
+							Usage:       "Eject and delete the cloud-init metadata ISO, which holds the login password, after cloud-init has run",
+							Destination: &vmEjectSeed,
+							EnvVar:      "QVSCLI_VM_EJECT_SEED",
+						},
+						cli.DurationFlag{
+							Name:        "seed-eject-delay",
+							Value:       5 * time.Minute,
+							Usage:       "Time to give cloud-init to finish after the VM started before ejecting the metadata ISO. When the VM is reachable with SSH, the ISO is ejected as soon as cloud-init has finished",
+							Destination: &vmSeedEjectDelay,
+						},
+						cli.IntFlag{
+							Name:        "serial",
+							Value:       0,
+							Usage:       "Add a serial-over-telnet console listening on this TCP port of the NAS, used by 'vm serial'",
+							Destination: &vmSerialPort,
+							EnvVar:      "QVSCLI_VM_SERIAL_PORT",
+						},
+						cli.BoolFlag{
+							Name:        "generate-key",
+							Usage:       "Generate an SSH keypair for this VM, kept in the secrets store, and authorize it instead of --authorized-key. Used by 'vm ssh' and 'vm scp'",
+							Destination: &vmGenerateKey,
+							EnvVar:      "QVSCLI_VM_GENERATE_KEY",
+						},
 						cli.StringFlag{
-							Name:        "startup-script",
+							Name:        "from-iso",
 							Value:       "",
-							Usage:       "Path to startup script to run as runcmd action in cloud-init",
-							Destination: &vmStartupScript,
-							EnvVar:      "QVSCLI_STARTUP_SCRIPT",
+							Usage:       "Install from an ISO on the NAS, or a local ISO that is uploaded first, onto a blank boot disk of --disk-size instead of copying an image",
+							Destination: &vmFromISO,
+							EnvVar:      "QVSCLI_VM_FROM_ISO",
 						},
 						cli.StringFlag{
-							Name:        "meta-data",
+							Name:        "answer-file",
 							Value:       "",
-							Usage:       "Path to meta-data file override for cloud-init, default is generated automatically",
-							Destination: &metaDataFile,
-							EnvVar:      "QVSCLI_META_DATA_FILE",
+							Usage:       "With --from-iso, generate an unattended installer answer file and attach it as a second ISO. One of: autoinstall, kickstart, preseed, autounattend",
+							Destination: &vmAnswerFile,
+							EnvVar:      "QVSCLI_VM_ANSWER_FILE",
 						},
 						cli.StringFlag{
-							Name:        "user-data",
+							Name:        "answer-template",
 							Value:       "",
-							Usage:       "Path to user-data file for cloud-init",
-							Destination: &userDataFile,
-							EnvVar:      "QVSCLI_USER_DATA_FILE",
+							Usage:       "Go template to render the answer file from instead of the built-in template for the --answer-file format",
+							Destination: &vmAnswerTemplate,
+							EnvVar:      "QVSCLI_VM_ANSWER_TEMPLATE",
 						},
 						cli.StringFlag{
-							Name:        "authorized-key",
-							Value:       defaultPubKeyFile,
-							Usage:       "Path to public ssh key file when --user-data is not provided.",
-							Destination: &vmAuthorizedKey,
-							EnvVar:      "QVSCLI_META_DATA_FILE",
+							Name:        "install-user",
+							Value:       "admin",
+							Usage:       "User created by the installer",
+							Destination: &vmInstallUser,
+							EnvVar:      "QVSCLI_VM_INSTALL_USER",
 						},
-						cli.BoolFlag{
-							Name:        "no-local-login",
-							Usage:       "Disable local login, a password will not be generated and only SSH can be used to access the VM.",
-							Destination: &vmNoLocalLogin,
-							EnvVar:      "QVSCLI_NO_LOCAL_LOGIN",
+						cli.StringFlag{
+							Name:        "timezone",
+							Value:       "UTC",
+							Usage:       "Time zone set by the installer, for example Europe/Berlin, or a Windows time zone name for autounattend",
+							Destination: &vmTimezone,
+							EnvVar:      "QVSCLI_VM_TIMEZONE",
 						},
-						cli.BoolFlag{
-							Name:        "no-cloud-init",
-							Usage:       "Disable cloud-init metadata ISO creation",
-							Destination: &noCloudInit,
-							EnvVar:      "QVSCLI_NO_CLOUD_INIT",
+						cli.StringFlag{
+							Name:        "locale",
+							Value:       "en_US.UTF-8",
+							Usage:       "Locale set by the installer",
+							Destination: &vmLocale,
+							EnvVar:      "QVSCLI_VM_LOCALE",
+						},
+						cli.StringFlag{
+							Name:        "keyboard",
+							Value:       "us",
+							Usage:       "Keyboard layout set by the installer",
+							Destination: &vmKeyboard,
+							EnvVar:      "QVSCLI_VM_KEYBOARD",
+						},
+						cli.StringFlag{
+							Name:        "disk-layout",
+							Value:       "lvm",
+							Usage:       "Boot disk layout used by the installer, lvm or plain",
+							Destination: &vmDiskLayout,
+							EnvVar:      "QVSCLI_VM_DISK_LAYOUT",
+						},
+						cli.StringFlag{
+							Name:        "windows-image",
+							Value:       "",
+							Usage:       "Windows edition to install with autounattend, by image index or name in the install.wim of the ISO, for example 2 or 'Windows 10 Pro'",
+							Destination: &vmWindowsImage,
+							EnvVar:      "QVSCLI_VM_WINDOWS_IMAGE",
+						},
+						cli.StringFlag{
+							Name:        "product-key",
+							Value:       "",
+							Usage:       "Windows product key used by autounattend",
+							Destination: &vmProductKey,
+							EnvVar:      "QVSCLI_VM_PRODUCT_KEY",
+						},
+						cli.StringFlag{
+							Name:        "os-type",
+							Value:       "",
+							Usage:       "QVS OS type, get names from 'qvscli os-types list'. Default is read from the image metadata, or linux",
+							Destination: &vmOSType,
+							EnvVar:      "QVSCLI_VM_OS_TYPE",
 						},
 						cli.StringFlag{
 							Name:        "image",
@@ -509,6 +1714,23 @@ func main() {
 							Destination: &vmImage,
 							EnvVar:      "QVSCLI_VM_IMAGE",
 						},
+						cli.BoolFlag{
+							Name:        "linked",
+							Usage:       "Create the boot disk as a qcow2 overlay backed by the base image instead of copying it",
+							Destination: &vmLinked,
+							EnvVar:      "QVSCLI_VM_LINKED",
+						},
+						cli.StringFlag{
+							Name:        "disk-size",
+							Value:       "",
+							Usage:       "Grow the boot disk to this size, for example 40G. Default is the base image size. Required with --from-iso",
+							Destination: &vmDiskSize,
+							EnvVar:      "QVSCLI_VM_DISK_SIZE",
+						},
+						cli.StringSliceFlag{
+							Name:  "data-disk",
+							Usage: "Create a blank data disk, repeatable. Format: size=100G[,bus=virtio][,cache=none][,format=qcow2][,fs=ext4][,mount=/mnt/data1|none]",
+						},
 						cli.StringFlag{
 							Name:        "mac",
 							Value:       "",
@@ -523,6 +1745,32 @@ func main() {
 							Destination: &vmNetName,
 							EnvVar:      "QVSCLI_VM_NET",
 						},
+						cli.StringSliceFlag{
+							Name:  "nic",
+							Usage: "Attach a network interface, repeatable, replaces --network, --mac, --ip, --gateway, --dns and --search. List values are separated by ';'. Format: network=br0[,mac=auto][,model=virtio][,vlan=20][,ip=10.0.0.50/24|dhcp][,gateway=10.0.0.1][,dns=10.0.0.2;10.0.0.3][,search=lan]",
+						},
+						cli.StringFlag{
+							Name:        "ip",
+							Value:       "",
+							Usage:       "Static IP of the VM with prefix length, for example 10.0.0.50/24, configured with a cloud-init network-config. Use auto to allocate one from the IP pool of the network. Default is DHCP",
+							Destination: &vmIP,
+							EnvVar:      "QVSCLI_VM_IP",
+						},
+						cli.StringFlag{
+							Name:        "gateway",
+							Value:       "",
+							Usage:       "Default gateway of the VM, requires --ip",
+							Destination: &vmGateway,
+							EnvVar:      "QVSCLI_VM_GATEWAY",
+						},
+						cli.StringSliceFlag{
+							Name:  "dns",
+							Usage: "DNS server of the VM, repeatable",
+						},
+						cli.StringSliceFlag{
+							Name:  "search",
+							Usage: "DNS search domain of the VM, repeatable",
+						},
 						cli.StringFlag{
 							Name:        "description, desc",
 							Value:       "",
@@ -544,228 +1792,1646 @@ func main() {
 							Destination: &vmMemoryGB,
 							EnvVar:      "QVSCLI_VM_MEM_GB",
 						},
-						cli.StringFlag{
-							Name:        "vnc-password",
-							Value:       "",
-							Usage:       "VNC password up to 8 characters long. If not set, one will be automatically generated.",
-							Destination: &vmVNCPassword,
-							EnvVar:      "QVSCLI_VM_VNC_PASSWORD",
+						cli.StringFlag{
+							Name:        "vnc-password",
+							Value:       "",
+							Usage:       "VNC password up to 8 characters long. If not set, one will be automatically generated.",
+							Destination: &vmVNCPassword,
+							EnvVar:      "QVSCLI_VM_VNC_PASSWORD",
+						},
+					}, append(cloudInitFlags, hardwareFlags...)...),
+					Action: func(c *cli.Context) error {
+						client := getClient()
+
+						name := c.Args().Get(0)
+
+						// Verify name is valid
+						if name == "" {
+							return fmt.Errorf("no instance name provided")
+						}
+						if err := validateVMName(name); err != nil {
+							return err
+						}
+						if vmVNCPassword != "" {
+							if err := validateVNCPassword(vmVNCPassword); err != nil {
+								return err
+							}
+						}
+						if vmEjectSeed && vmNoStart {
+							return fmt.Errorf("--eject-seed-after-boot cannot be combined with --no-start")
+						}
+
+						users, err := cloudUsers(vmUsersFile, c.StringSlice("add-user"))
+						if err != nil {
+							return err
+						}
+						values, err := loadTemplateValues(templateValuesFile, c.StringSlice("set"))
+						if err != nil {
+							return err
+						}
+						if err := validateSSHImportIDs(c.StringSlice("ssh-import-id")); err != nil {
+							return err
+						}
+
+						// Unlock the secrets store before anything is created, generated credentials are saved to it.
+						secrets := getSecrets()
+						if err := secrets.Unlock(); err != nil {
+							return err
+						}
+						vmSecrets := make(map[string]string)
+						if vmFromISO != "" {
+							if c.IsSet("image") || vmLinked {
+								return fmt.Errorf("--from-iso cannot be combined with --image or --linked")
+							}
+							if vmDiskSize == "" {
+								return fmt.Errorf("--from-iso requires --disk-size for the blank boot disk")
+							}
+						}
+						if vmAnswerFile != "" {
+							if vmFromISO == "" {
+								return fmt.Errorf("--answer-file requires --from-iso")
+							}
+							if _, err := lookupAnswerFormat(vmAnswerFile); err != nil {
+								return err
+							}
+							if !stringInSlice(vmDiskLayout, diskLayouts) {
+								return fmt.Errorf("invalid disk layout '%s', valid values are: %s", vmDiskLayout, strings.Join(diskLayouts, ", "))
+							}
+						} else if vmAnswerTemplate != "" {
+							return fmt.Errorf("--answer-template requires --answer-file")
+						}
+						if (vmWindowsImage != "" || vmProductKey != "") && vmAnswerFile != "autounattend" {
+							return fmt.Errorf("--windows-image and --product-key require --answer-file autounattend")
+						}
+						windowsImageIndex, windowsImageName, err := parseWindowsImage(vmWindowsImage)
+						if err != nil {
+							return err
+						}
+
+						// Network interfaces
+						var nics []NIC
+						if len(c.StringSlice("nic")) > 0 {
+							for _, f := range []string{"network", "mac", "ip", "gateway", "dns", "search"} {
+								if c.IsSet(f) {
+									return fmt.Errorf("--nic cannot be combined with --%s, set it in the --nic spec", f)
+								}
+							}
+							for _, spec := range c.StringSlice("nic") {
+								n, err := parseNIC(spec)
+								if err != nil {
+									return err
+								}
+								nics = append(nics, n)
+							}
+						} else {
+							n := NIC{Network: vmNetName, MAC: vmMACAddress, IP: vmIP, Gateway: vmGateway, DNS: c.StringSlice("dns"), Search: c.StringSlice("search")}
+							if n.IP == "dhcp" {
+								n.IP = ""
+							}
+							if err := n.validateAddressing(); err != nil {
+								return err
+							}
+							nics = []NIC{n}
+						}
+
+						if noCloudInit && needsNetworkConfig(nics) {
+							return fmt.Errorf("static IPs, DNS servers and search domains are configured by cloud-init and cannot be combined with --no-cloud-init")
+						}
+
+						// Generate MAC addresses
+						for i := range nics {
+							if nics[i].MAC == "" {
+								var err error
+								nics[i].MAC, err = client.MACCreate()
+								if err != nil {
+									return err
+								}
+								log.Printf("INFO: Generated new MAC address for instance: %s", nics[i].MAC)
+							}
+						}
+
+						// Static IPs from the IP pools, released again if the VM is not created.
+						var leasedMACs []string
+						vmCreated := false
+						defer func() {
+							if vmCreated || len(leasedMACs) == 0 {
+								return
+							}
+							if _, err := client.IPAMRelease(getIPAMFile(), "", leasedMACs); err != nil {
+								log.Printf("WARN: could not release IPs of %s: %v", name, err)
+							}
+						}()
+						for i := range nics {
+							if nics[i].IP == ipAuto {
+								// Pools are kept by QVS network name, --nic accepts the display name too.
+								if nics[i].Network, err = client.QVSNetName(nics[i].Network); err != nil {
+									return err
+								}
+								n, err := client.IPAMAllocate(getIPAMFile(), nics[i], name)
+								if err != nil {
+									return err
+								}
+								nics[i] = n
+								leasedMACs = append(leasedMACs, n.MAC)
+							}
+						}
+
+						// Verify image exists
+						vmImageSrc := filepath.Join(qvsImagesDir, vmImage)
+						var imageMeta *ImageMetadata
+						if vmFromISO == "" {
+							imageFiles, err := client.ListDir(filepath.Dir(vmImageSrc))
+							if err != nil {
+								return err
+							}
+							found := false
+							for _, imageFile := range imageFiles {
+								if imageFile.Filename == filepath.Base(vmImage) {
+									found = true
+									break
+								}
+							}
+							if found == false {
+								return fmt.Errorf("VM image file not found: %s", vmImage)
+							}
+
+							// Defaults recorded by 'vm to-image'
+							imageMeta, err = client.ImageMetadataGet(vmImageSrc)
+							if err != nil {
+								return err
+							}
+						}
+						vmMemory := int64(vmMemoryGB) << 30
+
+						// OS type from flag, image metadata or default
+						var osType OSType
+						if vmOSType != "" {
+							if osType, err = lookupOSType(vmOSType); err != nil {
+								return err
+							}
+						} else if imageMeta != nil && imageMeta.OSType != "" {
+							if osType, err = lookupOSType(imageMeta.OSType); err != nil {
+								return fmt.Errorf("image %s: %v, pass a valid --os-type", vmImage, err)
+							}
+						} else {
+							osType, _ = lookupOSType(defaultOSType)
+						}
+						if !osType.CloudInit && !noCloudInit && metaDataFile == "" && userDataFile == "" {
+							log.Printf("INFO: %s does not use cloud-init, skipping metadata ISO creation. Pass --user-data or --meta-data to create it anyway.", osType.Name)
+							noCloudInit = true
+						}
+						if vmFromISO != "" && !noCloudInit && metaDataFile == "" && userDataFile == "" {
+							log.Printf("INFO: Installing from ISO, skipping metadata ISO creation. Pass --user-data or --meta-data to create it anyway.")
+							noCloudInit = true
+						}
+						if noCloudInit && needsNetworkConfig(nics) {
+							return fmt.Errorf("static IPs, DNS servers and search domains are configured by cloud-init, which is skipped for this VM. Pass --user-data or --meta-data to use it")
+						}
+						if vmAnswerFile == "autoinstall" && !noCloudInit {
+							return fmt.Errorf("--answer-file autoinstall uses a cidata ISO and cannot be combined with --user-data or --meta-data")
+						}
+
+						if imageMeta != nil {
+							if imageMeta.Cores > 0 && !c.IsSet("cores") {
+								vmCores = imageMeta.Cores
+							}
+							if imageMeta.Memory > 0 && !c.IsSet("memory") {
+								vmMemory = imageMeta.Memory
+							}
+							if imageMeta.Format != "" && !stringInSlice(imageMeta.Format, diskFormats) {
+								return fmt.Errorf("invalid disk format '%s' in the metadata of image %s", imageMeta.Format, vmImage)
+							}
+							log.Printf("INFO: Using defaults from image %s: os type %s, %d cores, %s memory", vmImage, osType.ID, vmCores, formatSize(uint64(vmMemory)))
+						}
+
+						// Boot disk size
+						var bootDiskSize uint64
+						if vmFromISO != "" {
+							bootDiskSize, err = parseSize(vmDiskSize)
+							if err != nil {
+								return err
+							}
+						} else if vmDiskSize != "" || imageMeta != nil {
+							_, imageSize, err := client.ImageInfo(vmImageSrc)
+							if err != nil {
+								return err
+							}
+							if vmDiskSize != "" {
+								bootDiskSize, err = parseSize(vmDiskSize)
+								if err != nil {
+									return err
+								}
+								if bootDiskSize < imageSize {
+									return fmt.Errorf("--disk-size %s is smaller than the base image size %s", vmDiskSize, formatSize(imageSize))
+								}
+							} else if imageMeta.DiskSize > imageSize {
+								bootDiskSize = imageMeta.DiskSize
+							}
+						}
+
+						// Data disks
+						var dataDisks []DataDisk
+						for i, spec := range c.StringSlice("data-disk") {
+							d, err := parseDataDisk(spec, i+1)
+							if err != nil {
+								return err
+							}
+							dataDisks = append(dataDisks, d)
+						}
+						for i := range dataDisks {
+							if dataDisks[i].Bus == "" {
+								dataDisks[i].Bus = osType.DiskBus
+							}
+						}
+						assignGuestDevs(osType.DiskBus, dataDisks)
+
+						// Firmware, machine, CPU and boot order
+						hw, err := newHardwareRequest(hwFirmware, hwSecureBoot, hwMachine, hwCPUModel, hwSockets, hwThreads, vmCores)
+						if err != nil {
+							return err
+						}
+						if hwAutostart {
+							hw.AutoStart = &hwAutostart
+						}
+						nCDROMs := 1
+						if vmFromISO != "" {
+							if !noCloudInit {
+								nCDROMs++
+							}
+							if vmAnswerFile != "" {
+								nCDROMs++
+							}
+						}
+						bootOrder, err := parseBootOrder(hwBootOrder, 1+len(dataDisks), nCDROMs)
+						if err != nil {
+							return err
+						}
+						if vmFromISO != "" && len(bootOrder) == 0 {
+							// The disk boots first, it is blank and skipped until the installer has run
+							// from the ISO, then the installed OS boots without ejecting the ISO.
+							bootOrder = []string{"disk0", "cdrom0"}
+						}
+
+						// Timestamp for generated artifacts
+						now := time.Now().UTC()
+						ts := now.Unix()
+
+						if vmDescription == "" {
+							vmDescription = fmt.Sprintf("Created with qvscli at %s", now.Format("20060102150405"))
+						}
+
+						// Create directory for VM disk
+						if err := client.EnsureDir(filepath.Join(qvsDisksDir, name)); err != nil {
+							return err
+						}
+
+						// Per-VM SSH key
+						if vmGenerateKey {
+							priv, pub, err := generateSSHKeypair("qvscli-" + name)
+							if err != nil {
+								return err
+							}
+							vmSecrets[secretSSHPrivateKey] = priv
+							vmSecrets[secretSSHPublicKey] = pub
+							keyFile, err := ioutil.TempFile("", "qvs-ssh-key")
+							if err != nil {
+								return err
+							}
+							defer os.Remove(keyFile.Name())
+							_, err = keyFile.WriteString(pub)
+							keyFile.Close()
+							if err != nil {
+								return err
+							}
+							vmAuthorizedKey = keyFile.Name()
+						}
+
+						// Userdata and metadata handling
+						seedImage := vmImage
+						if vmFromISO != "" {
+							seedImage = filepath.Base(vmFromISO)
+						}
+						metadataISODest := ""
+						if noCloudInit {
+							log.Printf("WARN: cloud-init disabled, skipping metadata ISO creation. You may not be able log into the VM after booting.")
+						} else {
+							dir, err := ioutil.TempDir("", "ci-metadata-iso")
+							defer os.RemoveAll(dir)
+							if err != nil {
+								return err
+							}
+							if userDataFile == "" && !vmNoLocalLogin {
+								if vmSecrets[secretLoginPassword], err = password.Generate(8, 2, 0, false, false); err != nil {
+									return err
+								}
+							}
+							metadataISOFile, err := makeSeedISO(dir, SeedConfig{
+								Name:              name,
+								TS:                ts,
+								MetaDataFile:      metaDataFile,
+								UserDataFile:      userDataFile,
+								AuthorizedKeyFile: vmAuthorizedKey,
+								StartupScriptFile: vmStartupScript,
+								LocalLogin:        !vmNoLocalLogin,
+								LoginPassword:     vmSecrets[secretLoginPassword],
+								SSHImportIDs:      c.StringSlice("ssh-import-id"),
+								Users:             users,
+								GrowRoot:          bootDiskSize > 0 && vmFromISO == "",
+								DataDisks:         dataDisks,
+								NICs:              nics,
+								NetworkConfigFile: networkConfigFile,
+								Image:             seedImage,
+								Values:            values,
+								Partials:          c.StringSlice("include"),
+								Template:          seedTemplate || len(c.StringSlice("set")) > 0 || templateValuesFile != "" || len(c.StringSlice("include")) > 0,
+							})
+							if err != nil {
+								return err
+							}
+							metadataISODest, err = client.UploadSeedISO(metadataISOFile, filepath.Join(qvsDisksDir, name))
+							if err != nil {
+								return err
+							}
+						}
+
+						installISO := ""
+						if vmFromISO != "" {
+							installISO, err = client.ResolveISO(vmFromISO, filepath.Join(qvsDisksDir, name))
+							if err != nil {
+								return err
+							}
+						}
+
+						// Unattended installer answer file
+						answerISODest := ""
+						if vmAnswerFile != "" {
+							dir, err := ioutil.TempDir("", "answer-iso")
+							if err != nil {
+								return err
+							}
+							defer os.RemoveAll(dir)
+							// Same password as the cloud-init login if there is one.
+							installPassword := vmSecrets[secretLoginPassword]
+							if installPassword == "" {
+								if installPassword, err = password.Generate(8, 2, 0, false, false); err != nil {
+									return err
+								}
+								vmSecrets[secretLoginPassword] = installPassword
+							}
+							answerISOFile, err := makeAnswerISO(dir, AnswerConfig{
+								Format:            vmAnswerFile,
+								TemplateFile:      vmAnswerTemplate,
+								Hostname:          name,
+								TS:                ts,
+								Username:          vmInstallUser,
+								Password:          installPassword,
+								AuthorizedKeyFile: vmAuthorizedKey,
+								Timezone:          vmTimezone,
+								Locale:            vmLocale,
+								Keyboard:          vmKeyboard,
+								DiskLayout:        vmDiskLayout,
+								UEFI:              hwFirmware == "uefi",
+								ImageIndex:        windowsImageIndex,
+								ImageName:         windowsImageName,
+								ProductKey:        vmProductKey,
+							})
+							if err != nil {
+								return err
+							}
+							answerISODest, err = client.UploadSeedISO(answerISOFile, filepath.Join(qvsDisksDir, name))
+							if err != nil {
+								return err
+							}
+						}
+
+						vmImagePath := ""
+						vmDiskFormat := ""
+						if vmFromISO != "" {
+							// Blank boot disk created by QVS
+							vmImagePath = filepath.Join(qvsDisksDir, name, fmt.Sprintf("boot_disk_%d.qcow2", ts))
+							vmDiskFormat = "qcow2"
+							log.Printf("INFO: Creating %s blank boot disk %s", formatSize(bootDiskSize), vmImagePath)
+						} else if vmLinked {
+							// Create qcow2 overlay backed by the base image
+							vmImagePath = filepath.Join(qvsDisksDir, name, fmt.Sprintf("boot_disk_%d.qcow2", ts))
+							vmDiskFormat = "qcow2"
+
+							log.Printf("INFO: Creating linked disk %s -> %s", vmImagePath, vmImageSrc)
+							if err := client.CreateLinkedDisk(vmImageSrc, vmImagePath, bootDiskSize); err != nil {
+								return err
+							}
+						} else {
+							// Remote copy image to VM disk directory
+							vmImageDest := filepath.Join(qvsDisksDir, name, filepath.Base(vmImage))
+							vmBootDiskFile := fmt.Sprintf("boot_disk_%d.img", ts)
+							vmImagePath = filepath.Join(filepath.Dir(vmImageDest), vmBootDiskFile)
+
+							if imageMeta != nil && imageMeta.Format != "" {
+								vmDiskFormat = imageMeta.Format
+							}
+
+							log.Printf("INFO: Remote copy VM image %s -> %s", vmImageSrc, vmImagePath)
+							if err := client.CopyFile(vmImageSrc, vmImageDest); err != nil {
+								return err
+							}
+							if err := client.RenameFile(filepath.Dir(vmImageDest), filepath.Base(vmImageDest), vmBootDiskFile); err != nil {
+								return err
+							}
+						}
+
+						bootDisk := map[string]string{
+							"creating_image": fmt.Sprintf("%t", vmFromISO != ""),
+							"path":           vmImagePath,
+							"bus":            osType.DiskBus,
+						}
+						if vmDiskFormat != "" {
+							bootDisk["format"] = vmDiskFormat
+						}
+						if bootDiskSize > 0 && !vmLinked {
+							bootDisk["size"] = fmt.Sprintf("%d", bootDiskSize)
+						}
+						vmDisks := []map[string]string{bootDisk}
+						for i, d := range dataDisks {
+							diskPath := filepath.Join(qvsDisksDir, name, dataDiskFileName(i+1, ts, d.Format))
+							log.Printf("INFO: Creating %s data disk %s", formatSize(d.Size), diskPath)
+							vmDisks = append(vmDisks, d.CreateRequest(diskPath))
+						}
+
+						// Generate VNC Password if not given
+						if vmVNCPassword == "" {
+							// Generate a password that is 8 characters long with 3 digits, 0 symbols,
+							// allowing upper and lower case letters, disallowing repeat characters.
+							vmVNCPassword, err = password.Generate(8, 2, 0, false, false)
+							if err != nil {
+								return err
+							}
+						}
+						vmSecrets[secretVNCPassword] = vmVNCPassword
+
+						var vmSerials []map[string]string
+						if vmSerialPort > 0 {
+							vmSerials = append(vmSerials, serialCreateRequest(vmSerialPort))
+						}
+
+						// Create VM
+						var vmAdapters []map[string]string
+						for _, n := range nics {
+							if n.Model == "" {
+								n.Model = osType.NICModel
+							}
+							vmAdapters = append(vmAdapters, n.CreateRequest())
+						}
+						vmCDROMs := []map[string]string{
+							{
+								"path": metadataISODest,
+							},
+						}
+						if installISO != "" {
+							vmCDROMs = []map[string]string{{"path": installISO}}
+							if answerISODest != "" {
+								vmCDROMs = append(vmCDROMs, map[string]string{"path": answerISODest})
+							}
+							if metadataISODest != "" {
+								vmCDROMs = append(vmCDROMs, map[string]string{"path": metadataISODest})
+							}
+						}
+						if len(bootOrder) > 0 {
+							applyBootOrder(bootOrder, vmDisks, vmCDROMs)
+						}
+						if err := client.VMCreate(name, vmDescription, osType.ID, vmCores, vmMemory, vmAdapters, vmCDROMs, vmDisks, vmSerials, vmVNCPassword, hw); err != nil {
+							return err
+						}
+						log.Printf("INFO: VM Created: %s.", name)
+						vmCreated = true
+						if len(leasedMACs) > 0 {
+							vm, err := client.VMGet(name)
+							if err != nil {
+								return err
+							}
+							if err := client.IPAMBind(getIPAMFile(), leasedMACs, vm.UUID); err != nil {
+								return err
+							}
+						}
+						if err := storeVMSecrets(client, secrets, name, vmSecrets, printSecrets); err != nil {
+							return err
+						}
+
+						// Start VM
+						if vmNoStart {
+							return fmt.Errorf("WARN: not starting newly created vm because --no-start flag was passed. To start VM, run: 'qvscli vm start %s", name)
+						} else {
+							id, err := client.VMGetID(name)
+							if err != nil {
+								return err
+							}
+							if err := client.VMStart(id); err != nil {
+								return err
+							} else {
+								v, err := client.VMGet(name)
+								if err != nil {
+									return err
+								}
+								log.Printf("INFO: VM started. VNC port: %d", v.Graphics[0].Port)
+							}
+
+							if vmEjectSeed && metadataISODest != "" {
+								log.Printf("INFO: Waiting up to %s for cloud-init before ejecting metadata ISO", vmSeedEjectDelay)
+								start := time.Now()
+								t, err := client.VMSSHTarget(name, defaultSSHUser, secrets, vmAuthorizedKey, true, vmSeedEjectDelay)
+								if err != nil {
+									log.Printf("WARN: could not check cloud-init over SSH: %v", err)
+									time.Sleep(vmSeedEjectDelay - time.Since(start))
+								} else {
+									t.Close()
+								}
+								if err := client.VMEjectISO(id, metadataISODest); err != nil {
+									return err
+								}
+								if err := client.DeleteFile(metadataISODest); err != nil {
+									return err
+								}
+								log.Printf("INFO: Ejected and deleted metadata ISO: %s", metadataISODest)
+							}
+						}
+						return nil
+					},
+				},
+				{
+					Name:      "clone",
+					Usage:     "clone a VM with all of its disks, from a stopped VM or a running VM via a temporary snapshot",
+					ArgsUsage: "[source vm] [new name]",
+					Flags: append([]cli.Flag{
+						cli.BoolFlag{
+							Name:        "no-start",
+							Usage:       "Do not auto-start VM after cloning",
+							Destination: &vmNoStart,
+							EnvVar:      "QVSCLI_VM_NO_START",
+						},
+						cli.StringFlag{
+							Name:        "description, desc",
+							Value:       "",
+							Usage:       "VM description. Default is auto-generated based on the source VM and creation time",
+							Destination: &vmDescription,
+						},
+						cli.StringFlag{
+							Name:        "vnc-password",
+							Value:       "",
+							Usage:       "VNC password up to 8 characters long. If not set and the source VM uses a VNC password, one will be automatically generated.",
+							Destination: &vmVNCPassword,
+							EnvVar:      "QVSCLI_VM_VNC_PASSWORD",
+						},
+					}, cloudInitFlags...),
+					Action: func(c *cli.Context) error {
+						client := getClient()
+
+						src, err := client.VMGet(c.Args().Get(0))
+						if err != nil {
+							return err
+						}
+						name := c.Args().Get(1)
+						if name == "" {
+							return fmt.Errorf("no instance name provided")
+						}
+						if err := validateVMName(name); err != nil {
+							return err
+						}
+						if _, err := client.VMGet(name); err == nil {
+							return fmt.Errorf("VM %s already exists", name)
+						}
+						if vmVNCPassword != "" {
+							if err := validateVNCPassword(vmVNCPassword); err != nil {
+								return err
+							}
+						}
+						if len(src.Disks) == 0 {
+							return fmt.Errorf("VM %s has no disks to clone", src.Name)
+						}
+						users, err := cloudUsers(vmUsersFile, c.StringSlice("add-user"))
+						if err != nil {
+							return err
+						}
+						values, err := loadTemplateValues(templateValuesFile, c.StringSlice("set"))
+						if err != nil {
+							return err
+						}
+						if err := validateSSHImportIDs(c.StringSlice("ssh-import-id")); err != nil {
+							return err
+						}
+						secrets := getSecrets()
+						if err := secrets.Unlock(); err != nil {
+							return err
+						}
+						vmSecrets := make(map[string]string)
+
+						now := time.Now().UTC()
+						ts := now.Unix()
+
+						// Running VMs are copied from the disks a native snapshot froze, while it is held.
+						var srcPaths []string
+						for _, d := range src.Disks {
+							srcPaths = append(srcPaths, d.Path)
+						}
+						if src.PowerState != QVSPowerStateStop {
+							srcID := fmt.Sprintf("%d", src.ID)
+							snapName := fmt.Sprintf("qvscli-clone-%s-%d", name, ts)
+							log.Printf("INFO: VM %s is %s, creating snapshot %s to clone from", src.Name, src.PowerState, snapName)
+							snap, err := client.VMSnapshotCreate(srcID, snapName, fmt.Sprintf("Temporary snapshot for qvscli clone %s", name))
+							if err != nil {
+								return err
+							}
+							defer func() {
+								if err := client.VMSnapshotDelete(srcID, snap.ID); err != nil {
+									log.Printf("WARN: failed to delete snapshot %s of VM %s: %v", snapName, src.Name, err)
+								} else {
+									log.Printf("INFO: Deleted snapshot %s of VM %s", snapName, src.Name)
+								}
+							}()
+							snapped, err := client.VMGet(srcID)
+							if err != nil {
+								return err
+							}
+							if srcPaths, err = frozenDiskPaths(src.Disks, snapped.Disks); err != nil {
+								return err
+							}
+						}
+
+						vmDir := filepath.Join(qvsDisksDir, name)
+						if err := client.EnsureDir(vmDir); err != nil {
+							return err
+						}
+
+						// Copy every disk
+						var vmDisks []map[string]string
+						for i, d := range src.Disks {
+							destFile := fmt.Sprintf("boot_disk_%d%s", ts, filepath.Ext(d.Path))
+							if i > 0 {
+								destFile = fmt.Sprintf("data_disk_%d_%d%s", i, ts, filepath.Ext(d.Path))
+							}
+							log.Printf("INFO: Remote copy VM disk %s -> %s", srcPaths[i], filepath.Join(vmDir, destFile))
+							if err := client.CopyFileAs(srcPaths[i], filepath.Join(vmDir, destFile)); err != nil {
+								return err
+							}
+							disk := map[string]string{
+								"creating_image": "false",
+								"path":           filepath.Join(vmDir, destFile),
+							}
+							for k, v := range map[string]string{"format": d.Format, "bus": d.Bus, "cache": d.Cache} {
+								if v != "" {
+									disk[k] = v
+								}
+							}
+							if d.BootOrder > 0 {
+								disk["boot_order"] = fmt.Sprintf("%d", d.BootOrder)
+							}
+							vmDisks = append(vmDisks, disk)
+						}
+
+						// New MAC addresses on the same networks
+						var nics []NIC
+						var vmAdapters []map[string]string
+						for _, a := range src.Adapters {
+							mac, err := client.MACCreate()
+							if err != nil {
+								return err
+							}
+							log.Printf("INFO: Generated new MAC address for instance: %s", mac)
+							n := NIC{Network: a.Bridge, MAC: mac, Model: a.Model, VLAN: a.VLAN}
+							nics = append(nics, n)
+							vmAdapters = append(vmAdapters, n.CreateRequest())
+						}
+
+						// New cloud-init seed so that cloud-init runs again with the new instance-id and hostname
+						metadataISODest := ""
+						if noCloudInit {
+							log.Printf("WARN: cloud-init disabled, the clone keeps the hostname and identity of %s.", src.Name)
+						} else {
+							dir, err := ioutil.TempDir("", "ci-metadata-iso")
+							if err != nil {
+								return err
+							}
+							defer os.RemoveAll(dir)
+							if userDataFile == "" && !vmNoLocalLogin {
+								if vmSecrets[secretLoginPassword], err = password.Generate(8, 2, 0, false, false); err != nil {
+									return err
+								}
+							}
+							metadataISOFile, err := makeSeedISO(dir, SeedConfig{
+								Name:              name,
+								TS:                ts,
+								MetaDataFile:      metaDataFile,
+								UserDataFile:      userDataFile,
+								AuthorizedKeyFile: vmAuthorizedKey,
+								StartupScriptFile: vmStartupScript,
+								LocalLogin:        !vmNoLocalLogin,
+								LoginPassword:     vmSecrets[secretLoginPassword],
+								SSHImportIDs:      c.StringSlice("ssh-import-id"),
+								Users:             users,
+								NetworkConfigFile: networkConfigFile,
+								NICs:              nics,
+								Values:            values,
+								Partials:          c.StringSlice("include"),
+								Template:          seedTemplate || len(c.StringSlice("set")) > 0 || templateValuesFile != "" || len(c.StringSlice("include")) > 0,
+							})
+							if err != nil {
+								return err
+							}
+							metadataISODest, err = client.UploadSeedISO(metadataISOFile, vmDir)
+							if err != nil {
+								return err
+							}
+						}
+
+						if vmVNCPassword == "" && len(src.Graphics) > 0 && src.Graphics[0].EnablePassword {
+							vmVNCPassword, err = password.Generate(8, 2, 0, false, false)
+							if err != nil {
+								return err
+							}
+						}
+						if vmVNCPassword != "" {
+							vmSecrets[secretVNCPassword] = vmVNCPassword
+						}
+
+						if vmDescription == "" {
+							vmDescription = fmt.Sprintf("Cloned from %s with qvscli at %s", src.Name, now.Format("20060102150405"))
+						}
+
+						vmCDROMs := []map[string]string{
+							{
+								"path": metadataISODest,
+							},
+						}
+						if len(src.CDROMs) > 0 && src.CDROMs[0].BootOrder > 0 {
+							vmCDROMs[0]["boot_order"] = fmt.Sprintf("%d", src.CDROMs[0].BootOrder)
+						}
+
+						hw := QVSHardwareRequest{
+							Firmware: src.Firmware,
+							Machine:  src.Machine,
+							CPUMode:  src.CPUMode,
+							CPUModel: src.CPUModel,
+							Sockets:  src.Sockets,
+							Threads:  src.Threads,
+						}
+						if src.SecureBoot {
+							hw.SecureBoot = &src.SecureBoot
+						}
+						if src.AutoStart {
+							hw.AutoStart = &src.AutoStart
+						}
+
+						if err := client.VMCreate(name, vmDescription, src.OSType, src.Cores, src.Memory, vmAdapters, vmCDROMs, vmDisks, nil, vmVNCPassword, hw); err != nil {
+							return err
+						}
+						log.Printf("INFO: VM %s cloned from %s.", name, src.Name)
+						if err := storeVMSecrets(client, secrets, name, vmSecrets, printSecrets); err != nil {
+							return err
+						}
+
+						if vmNoStart {
+							return fmt.Errorf("WARN: not starting cloned vm because --no-start flag was passed. To start VM, run: 'qvscli vm start %s", name)
+						}
+						return vmPower(name, "start")
+					},
+				},
+				{
+					Name:      "to-image",
+					Usage:     "copy the boot disk of a stopped VM into the qvs-images-dir as a reusable image",
+					ArgsUsage: "[vm] [image name]",
+					Action: func(c *cli.Context) error {
+						client := getClient()
+						vm, err := client.VMGet(c.Args().Get(0))
+						if err != nil {
+							return err
+						}
+						imageName := c.Args().Get(1)
+						if imageName == "" {
+							return fmt.Errorf("no image name provided")
+						}
+						if err := requireStopped(vm, "create an image"); err != nil {
+							return err
+						}
+						if len(vm.Disks) == 0 {
+							return fmt.Errorf("VM %s has no disks", vm.Name)
+						}
+						bootDisk := vm.Disks[0]
+
+						// Linked disks only hold the changes to their base image.
+						head, err := client.ReadFileHead(bootDisk.Path, qcow2ClusterSize)
+						if err != nil {
+							return err
+						}
+						if isQcow2(head) {
+							h, err := parseQcow2Header(head)
+							if err != nil {
+								return err
+							}
+							if h.BackingFile != "" {
+								return fmt.Errorf("boot disk of VM %s is a linked disk backed by %s, only standalone disks can be turned into images", vm.Name, resolveBackingPath(bootDisk.Path, h.BackingFile))
+							}
+						}
+
+						if filepath.Ext(imageName) == "" {
+							imageName += filepath.Ext(bootDisk.Path)
+						}
+						imagePath := filepath.Join(qvsImagesDir, imageName)
+						if _, _, err := client.ImageInfo(imagePath); err == nil {
+							return fmt.Errorf("image already exists: %s", imagePath)
+						} else if !os.IsNotExist(err) {
+							return err
+						}
+						if err := client.EnsureDir(filepath.Dir(imagePath)); err != nil {
+							return err
+						}
+
+						log.Printf("INFO: Remote copy VM disk %s -> %s", bootDisk.Path, imagePath)
+						if err := client.CopyFileAs(bootDisk.Path, imagePath); err != nil {
+							return err
+						}
+
+						meta := ImageMetadata{
+							SourceVM:   vm.Name,
+							SourceUUID: vm.UUID,
+							OSType:     vm.OSType,
+							Cores:      vm.Cores,
+							Memory:     vm.Memory,
+							DiskSize:   uint64(bootDisk.Size),
+							Format:     bootDisk.Format,
+							CreatedAt:  time.Now().UTC().Format(time.RFC3339),
+						}
+						if err := client.ImageMetadataPut(imagePath, meta); err != nil {
+							return err
+						}
+						log.Printf("INFO: Created image %s from VM %s, create VMs from it with: 'qvscli vm create --image %s'", imagePath, vm.Name, imageName)
+						return nil
+					},
+				},
+				{
+					Name:      "set",
+					Usage:     "modify cores, memory, hardware options, name or description of a VM by ID or name",
+					ArgsUsage: "[vm]",
+					Flags: append([]cli.Flag{
+						cli.IntFlag{
+							Name:        "cores",
+							Usage:       "Number of cores for VM",
+							Destination: &vmSetCores,
+						},
+						cli.StringFlag{
+							Name:        "memory, mem",
+							Usage:       "Memory for VM, for example 8G or 512M. A plain number is taken as Gigabytes",
+							Destination: &vmSetMemory,
+						},
+						cli.StringFlag{
+							Name:        "name",
+							Usage:       "New VM name",
+							Destination: &vmSetName,
+						},
+						cli.StringFlag{
+							Name:        "description, desc",
+							Usage:       "New VM description",
+							Destination: &vmSetDescription,
+						},
+						cli.BoolFlag{
+							Name:        "hotplug",
+							Usage:       "Add cores and memory to the running VM without a restart, the guest OS must support CPU and memory hot-plug",
+							Destination: &vmSetHotplug,
+						},
+						cli.BoolFlag{
+							Name:        "restart",
+							Usage:       "Shut down the running VM to apply changes that need it stopped, then start it again",
+							Destination: &vmSetRestart,
+						},
+						cli.DurationFlag{
+							Name:        "timeout",
+							Value:       5 * time.Minute,
+							Usage:       "Time to wait for the VM to shut down with --restart",
+							Destination: &vmWaitTimeout,
+						},
+						cli.BoolFlag{
+							Name:        "no-autostart",
+							Usage:       "Do not start the VM when the NAS boots",
+							Destination: &hwNoAutostart,
+						},
+					}, hardwareFlags...),
+					Action: func(c *cli.Context) error {
+						client := getClient()
+						vm, err := client.VMGet(c.Args().First())
+						if err != nil {
+							return err
+						}
+						id := fmt.Sprintf("%d", vm.ID)
+
+						var update QVSUpdateRequest
+						var needsStop []string
+						moveFolder := false
+						if c.IsSet("name") && vmSetName != vm.Name {
+							if err := validateVMName(vmSetName); err != nil {
+								return err
+							}
+							update.Name = vmSetName
+
+							// The folder of the VM disks is renamed with the VM.
+							files, err := client.ListDir(qvsDisksDir)
+							if err != nil {
+								return err
+							}
+							for _, f := range files {
+								if f.IsFolder != 1 {
+									continue
+								}
+								if f.Filename == vmSetName {
+									return fmt.Errorf("folder %s already exists, can not rename VM %s to %s", filepath.Join(qvsDisksDir, vmSetName), vm.Name, vmSetName)
+								}
+								if f.Filename == vm.Name {
+									moveFolder = true
+								}
+							}
+							if moveFolder {
+								needsStop = append(needsStop, "name")
+							}
+						}
+						if c.IsSet("description") && vmSetDescription != vm.Description {
+							update.Description = &vmSetDescription
+						}
+						if c.IsSet("cores") && vmSetCores != vm.Cores {
+							if vmSetCores < 1 {
+								return fmt.Errorf("invalid number of cores: %d", vmSetCores)
+							}
+							update.Cores = vmSetCores
+							if vmSetCores < vm.Cores || !vmSetHotplug {
+								needsStop = append(needsStop, "cores")
+							}
+						}
+						if c.IsSet("memory") {
+							memory, err := parseMemory(vmSetMemory)
+							if err != nil {
+								return err
+							}
+							if int64(memory) != vm.Memory {
+								update.Memory = int64(memory)
+								if update.Memory < vm.Memory || !vmSetHotplug {
+									needsStop = append(needsStop, "memory")
+								}
+							}
+						}
+
+						cores := vm.Cores
+						if update.Cores > 0 {
+							cores = update.Cores
+						}
+						// Secure boot is checked against the current firmware if it is not changed.
+						firmware := hwFirmware
+						if hwSecureBoot && firmware == "" {
+							firmware = vm.Firmware
+						}
+						hw, err := newHardwareRequest(firmware, hwSecureBoot, hwMachine, hwCPUModel, hwSockets, hwThreads, cores)
+						if err != nil {
+							return err
+						}
+						if hw != (QVSHardwareRequest{}) {
+							needsStop = append(needsStop, "firmware, machine and CPU options")
+						}
+						if hwAutostart && hwNoAutostart {
+							return fmt.Errorf("--autostart and --no-autostart are mutually exclusive")
+						}
+						if hwAutostart || hwNoAutostart {
+							hw.AutoStart = &hwAutostart
+						}
+						update.QVSHardwareRequest = hw
+
+						var bootOrder []string
+						if hwBootOrder != "" {
+							if bootOrder, err = parseBootOrder(hwBootOrder, len(vm.Disks), len(vm.CDROMs)); err != nil {
+								return err
+							}
+							needsStop = append(needsStop, "boot order")
+						}
+
+						if update == (QVSUpdateRequest{}) && len(bootOrder) == 0 {
+							return fmt.Errorf("nothing to change, use --cores, --memory, --name, --description or the hardware options")
+						}
+
+						restart := false
+						if vm.PowerState != QVSPowerStateStop && len(needsStop) > 0 {
+							if !vmSetRestart {
+								return fmt.Errorf("changing %s requires VM %s to be stopped, stop it first or pass --restart", strings.Join(needsStop, " and "), vm.Name)
+							}
+							log.Printf("INFO: Shutting down VM %s to apply changes to %s", vm.Name, strings.Join(needsStop, " and "))
+							if err := client.VMShutdown(id, false); err != nil {
+								return err
+							}
+							if _, err := client.VMWaitState(id, QVSPowerStateStop, vmWaitTimeout); err != nil {
+								return err
+							}
+							restart = true
+						}
+
+						if moveFolder {
+							if err := client.VMMoveFolder(vm, qvsDisksDir, update.Name); err != nil {
+								return err
+							}
+						}
+						if update != (QVSUpdateRequest{}) {
+							if err := client.VMUpdate(id, update); err != nil {
+								return err
+							}
+						}
+						if len(bootOrder) > 0 {
+							for i, d := range vm.Disks {
+								if err := client.VMDiskUpdate(id, d.ID, map[string]string{"boot_order": fmt.Sprintf("%d", bootOrderOf(bootOrder, fmt.Sprintf("disk%d", i)))}); err != nil {
+									return err
+								}
+							}
+							for i, cd := range vm.CDROMs {
+								if err := client.VMCDROMUpdate(id, cd.ID, map[string]string{"boot_order": fmt.Sprintf("%d", bootOrderOf(bootOrder, fmt.Sprintf("cdrom%d", i)))}); err != nil {
+									return err
+								}
+							}
+						}
+						log.Printf("INFO: Updated VM: %s", vm.Name)
+
+						if restart {
+							if err := client.VMStart(id); err != nil {
+								return err
+							}
+							log.Printf("INFO: started VM: %s", vm.Name)
+						}
+						return nil
+					},
+				},
+				{
+					Name:    "disk",
+					Aliases: []string{"disks"},
+					Usage:   "options for VM disks",
+					Subcommands: []cli.Command{
+						{
+							Name:      "list",
+							Aliases:   []string{"ls"},
+							Usage:     "list disks of a VM by ID or name",
+							ArgsUsage: "[vm]",
+							Flags: []cli.Flag{
+								cli.StringFlag{
+									Name:        "output, o",
+									Usage:       "Output format, text or json",
+									Value:       "text",
+									Destination: &outputFormat,
+								},
+							},
+							Action: func(c *cli.Context) error {
+								client := getClient()
+								vm, err := client.VMGet(c.Args().First())
+								if err != nil {
+									return err
+								}
+								if outputFormat == "json" {
+									pretty, _ := json.MarshalIndent(vm.Disks, "", "  ")
+									fmt.Println(string(pretty))
+								} else if outputFormat == "text" {
+									w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', 0)
+									fmt.Fprintln(w, "DEV\tBUS\tCACHE\tFORMAT\tSIZE\tBOOT ORDER\tPATH")
+									for _, d := range vm.Disks {
+										fmt.Fprintln(w, strings.Join([]string{
+											d.Dev,
+											d.Bus,
+											d.Cache,
+											d.Format,
+											formatSize(uint64(d.Size)),
+											fmt.Sprintf("%d", d.BootOrder),
+											d.Path,
+										}, "\t"))
+									}
+									w.Flush()
+								} else {
+									return fmt.Errorf("invalid output format %s", outputFormat)
+								}
+								return nil
+							},
+						},
+						{
+							Name:      "add",
+							Usage:     "add a blank or existing disk image to a stopped VM",
+							ArgsUsage: "[vm]",
+							Flags: []cli.Flag{
+								cli.StringFlag{
+									Name:        "size",
+									Usage:       "Size of the new blank disk, for example 100G",
+									Destination: &diskSize,
+								},
+								cli.StringFlag{
+									Name:        "path",
+									Usage:       "NAS path of an existing disk image to attach instead of creating a blank disk",
+									Destination: &diskPath,
+								},
+								cli.StringFlag{
+									Name:        "bus",
+									Value:       defaultDiskBus,
+									Usage:       "Disk bus: " + strings.Join(diskBuses, ", "),
+									Destination: &diskBus,
+								},
+								cli.StringFlag{
+									Name:        "cache",
+									Value:       "none",
+									Usage:       "Disk cache mode: " + strings.Join(diskCaches, ", "),
+									Destination: &diskCache,
+								},
+								cli.StringFlag{
+									Name:        "format",
+									Value:       "qcow2",
+									Usage:       "Disk image format of the new blank disk: " + strings.Join(diskFormats, ", "),
+									Destination: &diskFormat,
+								},
+							},
+							Action: func(c *cli.Context) error {
+								client := getClient()
+								vm, err := client.VMGet(c.Args().First())
+								if err != nil {
+									return err
+								}
+								if err := requireStopped(vm, "add a disk"); err != nil {
+									return err
+								}
+								if err := validateDiskOptions(diskBus, diskCache, diskFormat); err != nil {
+									return err
+								}
+
+								var disk map[string]string
+								if diskPath != "" {
+									if diskSize != "" {
+										return fmt.Errorf("--size and --path are mutually exclusive")
+									}
+									disk = map[string]string{
+										"creating_image": "false",
+										"path":           diskPath,
+										"bus":            diskBus,
+										"cache":          diskCache,
+									}
+								} else {
+									if diskSize == "" {
+										return fmt.Errorf("--size or --path is required")
+									}
+									size, err := parseSize(diskSize)
+									if err != nil {
+										return err
+									}
+									d := DataDisk{Size: size, Bus: diskBus, Cache: diskCache, Format: diskFormat}
+									diskPath = filepath.Join(qvsDisksDir, vm.Name, dataDiskFileName(len(vm.Disks), time.Now().UTC().Unix(), diskFormat))
+									disk = d.CreateRequest(diskPath)
+								}
+
+								if err := client.VMDiskAdd(fmt.Sprintf("%d", vm.ID), disk); err != nil {
+									return err
+								}
+								log.Printf("INFO: Added disk %s to VM: %s", diskPath, vm.Name)
+								return nil
+							},
+						},
+						{
+							Name:      "remove",
+							Aliases:   []string{"rm", "detach"},
+							Usage:     "detach a disk from a stopped VM",
+							ArgsUsage: "[vm] [disk dev, index or file name]",
+							Flags: []cli.Flag{
+								cli.BoolFlag{
+									Name:        "delete-file",
+									Usage:       "Also delete the disk image file from the NAS",
+									Destination: &diskDeleteFile,
+								},
+							},
+							Action: func(c *cli.Context) error {
+								client := getClient()
+								vm, err := client.VMGet(c.Args().Get(0))
+								if err != nil {
+									return err
+								}
+								disk, err := findVMDisk(vm, c.Args().Get(1))
+								if err != nil {
+									return err
+								}
+								if err := requireStopped(vm, "remove a disk"); err != nil {
+									return err
+								}
+								if err := client.VMDiskRemove(fmt.Sprintf("%d", vm.ID), disk.ID); err != nil {
+									return err
+								}
+								log.Printf("INFO: Detached disk %s from VM: %s", disk.Path, vm.Name)
+
+								if diskDeleteFile {
+									if err := client.DeleteFile(disk.Path); err != nil {
+										return err
+									}
+									log.Printf("INFO: Deleted disk image: %s", disk.Path)
+								}
+								return nil
+							},
+						},
+						{
+							Name:      "resize",
+							Usage:     "grow a disk of a stopped VM",
+							ArgsUsage: "[vm] [disk dev, index or file name] [size]",
+							Action: func(c *cli.Context) error {
+								client := getClient()
+								vm, err := client.VMGet(c.Args().Get(0))
+								if err != nil {
+									return err
+								}
+								disk, err := findVMDisk(vm, c.Args().Get(1))
+								if err != nil {
+									return err
+								}
+								size, err := parseSize(c.Args().Get(2))
+								if err != nil {
+									return err
+								}
+								if size < uint64(disk.Size) {
+									return fmt.Errorf("error, shrinking disks is not supported, %s is smaller than the current size %s", formatSize(size), formatSize(uint64(disk.Size)))
+								}
+								if err := requireStopped(vm, "resize a disk"); err != nil {
+									return err
+								}
+								if err := client.VMDiskUpdate(fmt.Sprintf("%d", vm.ID), disk.ID, map[string]string{"size": fmt.Sprintf("%d", size)}); err != nil {
+									return err
+								}
+								log.Printf("INFO: Resized disk %s of VM %s to %s, grow the partition and filesystem inside the guest to use it.", disk.Dev, vm.Name, formatSize(size))
+								return nil
+							},
+						},
+						{
+							Name:      "set",
+							Usage:     "change the bus or cache mode of a disk of a stopped VM",
+							ArgsUsage: "[vm] [disk dev, index or file name]",
+							Flags: []cli.Flag{
+								cli.StringFlag{
+									Name:        "bus",
+									Usage:       "Disk bus: " + strings.Join(diskBuses, ", "),
+									Destination: &diskBus,
+								},
+								cli.StringFlag{
+									Name:        "cache",
+									Usage:       "Disk cache mode: " + strings.Join(diskCaches, ", "),
+									Destination: &diskCache,
+								},
+							},
+							Action: func(c *cli.Context) error {
+								client := getClient()
+								vm, err := client.VMGet(c.Args().Get(0))
+								if err != nil {
+									return err
+								}
+								disk, err := findVMDisk(vm, c.Args().Get(1))
+								if err != nil {
+									return err
+								}
+								if err := validateDiskOptions(diskBus, diskCache, ""); err != nil {
+									return err
+								}
+								changes := make(map[string]string)
+								if diskBus != "" {
+									changes["bus"] = diskBus
+								}
+								if diskCache != "" {
+									changes["cache"] = diskCache
+								}
+								if len(changes) == 0 {
+									return fmt.Errorf("nothing to change, use --bus and/or --cache")
+								}
+								if err := requireStopped(vm, "change a disk"); err != nil {
+									return err
+								}
+								if err := client.VMDiskUpdate(fmt.Sprintf("%d", vm.ID), disk.ID, changes); err != nil {
+									return err
+								}
+								log.Printf("INFO: Updated disk %s of VM: %s", disk.Dev, vm.Name)
+								return nil
+							},
+						},
+					},
+				},
+				{
+					Name:    "nic",
+					Aliases: []string{"nics"},
+					Usage:   "options for VM network adapters",
+					Subcommands: []cli.Command{
+						{
+							Name:      "list",
+							Aliases:   []string{"ls"},
+							Usage:     "list network adapters of a VM by ID or name",
+							ArgsUsage: "[vm]",
+							Flags: []cli.Flag{
+								cli.StringFlag{
+									Name:        "output, o",
+									Usage:       "Output format, text or json",
+									Value:       "text",
+									Destination: &outputFormat,
+								},
+							},
+							Action: func(c *cli.Context) error {
+								client := getClient()
+								vm, err := client.VMGet(c.Args().First())
+								if err != nil {
+									return err
+								}
+								if outputFormat == "json" {
+									pretty, _ := json.MarshalIndent(vm.Adapters, "", "  ")
+									fmt.Println(string(pretty))
+								} else if outputFormat == "text" {
+									w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', 0)
+									fmt.Fprintln(w, "INDEX\tNETWORK\tMAC ADDRESS\tMODEL\tVLAN")
+									for _, a := range vm.Adapters {
+										vlan := ""
+										if a.VLAN > 0 {
+											vlan = fmt.Sprintf("%d", a.VLAN)
+										}
+										fmt.Fprintln(w, strings.Join([]string{
+											fmt.Sprintf("%d", a.Index),
+											a.Bridge,
+											a.MAC,
+											a.Model,
+											vlan,
+										}, "\t"))
+									}
+									w.Flush()
+								} else {
+									return fmt.Errorf("invalid output format %s", outputFormat)
+								}
+								return nil
+							},
+						},
+						{
+							Name:      "add",
+							Usage:     "add a network adapter to a VM",
+							ArgsUsage: "[vm]",
+							Flags: []cli.Flag{
+								cli.StringFlag{
+									Name:        "network, net",
+									Value:       "br0",
+									Usage:       "Network to attach, get names from 'qvscli net list'",
+									Destination: &nicNetwork,
+								},
+								cli.StringFlag{
+									Name:        "mac",
+									Usage:       "MAC address of the adapter, if not set, one will be created",
+									Destination: &nicMAC,
+								},
+								cli.StringFlag{
+									Name:        "model",
+									Value:       "virtio",
+									Usage:       "Adapter model: " + strings.Join(nicModels, ", "),
+									Destination: &nicModel,
+								},
+								cli.IntFlag{
+									Name:        "vlan",
+									Usage:       "VLAN ID, 0 for untagged",
+									Destination: &nicVLAN,
+								},
+							},
+							Action: func(c *cli.Context) error {
+								client := getClient()
+								vm, err := client.VMGet(c.Args().First())
+								if err != nil {
+									return err
+								}
+								n := NIC{Network: nicNetwork, MAC: nicMAC, Model: nicModel, VLAN: nicVLAN}
+								if n.Network, err = client.QVSNetName(n.Network); err != nil {
+									return err
+								}
+								if err := validateNICModel(n.Model); err != nil {
+									return err
+								}
+								if err := validateVLAN(n.VLAN); err != nil {
+									return err
+								}
+								if n.MAC == "" {
+									n.MAC, err = client.MACCreate()
+									if err != nil {
+										return err
+									}
+									log.Printf("INFO: Generated new MAC address: %s", n.MAC)
+								}
+								if err := client.VMAdapterAdd(fmt.Sprintf("%d", vm.ID), n.CreateRequest()); err != nil {
+									return err
+								}
+								log.Printf("INFO: Added network adapter %s on %s to VM: %s", n.MAC, n.Network, vm.Name)
+								return nil
+							},
+						},
+						{
+							Name:      "remove",
+							Aliases:   []string{"rm"},
+							Usage:     "remove a network adapter from a VM",
+							ArgsUsage: "[vm] [mac address or index]",
+							Action: func(c *cli.Context) error {
+								client := getClient()
+								vm, err := client.VMGet(c.Args().Get(0))
+								if err != nil {
+									return err
+								}
+								a, err := findVMAdapter(vm, c.Args().Get(1))
+								if err != nil {
+									return err
+								}
+								if err := client.VMAdapterRemove(fmt.Sprintf("%d", vm.ID), a.ID); err != nil {
+									return err
+								}
+								log.Printf("INFO: Removed network adapter %s from VM: %s", a.MAC, vm.Name)
+								return nil
+							},
 						},
-					},
-					Action: func(c *cli.Context) error {
-						client := getClient()
-
-						name := c.Args().Get(0)
-
-						// Verify name is valid
-						if name == "" {
-							return fmt.Errorf("no instance name provided")
-						}
-						nameRegex := regexp.MustCompile(`^[[:alnum:]][[:alnum:]\-]{0,61}[[:alnum:]]|[[:alpha:]]$`)
-						if !nameRegex.MatchString(name) {
-							return fmt.Errorf("invalid instance name: %s", name)
-						}
-
-						// Generate MAC address
-						if vmMACAddress == "" {
-							var err error
-							vmMACAddress, err = client.MACCreate()
-							if err != nil {
-								return err
-							}
-							log.Printf("INFO: Generated new MAC address for instance: %s", vmMACAddress)
-						}
-
-						// Verify image exists
-						vmImageSrc := filepath.Join(qvsImagesDir, vmImage)
-						imageFiles, err := client.ListDir(filepath.Dir(vmImageSrc))
-						if err != nil {
-							return err
-						}
-						found := false
-						for _, imageFile := range imageFiles {
-							if imageFile.Filename == filepath.Base(vmImage) {
-								found = true
-								break
-							}
-						}
-						if found == false {
-							return fmt.Errorf("VM image file not found: %s", vmImage)
-						}
-
-						// Timestamp for generated artifacts
-						now := time.Now().UTC()
-						ts := now.Unix()
-
-						// Userdata and metadata handling
-						metadataISOFile := ""
-						metadataISODest := ""
-						if noCloudInit {
-							log.Printf("WARN: cloud-init disabled, skipping metadata ISO creation. You may not be able log into the VM after booting.")
-						} else {
-							dir, err := ioutil.TempDir("", "ci-metadata-iso")
-							defer os.RemoveAll(dir)
-							if err != nil {
-								return err
-							}
-							metadataISOFile = filepath.Join(dir, fmt.Sprintf("metadata_%d.iso", ts))
-
-							if metaDataFile == "" {
-								metaDataFile = filepath.Join(dir, fmt.Sprintf("meta-data"))
-								mf, err := os.OpenFile(metaDataFile, os.O_RDWR|os.O_CREATE, 0644)
+						{
+							Name:      "set",
+							Usage:     "change the network, model or VLAN of a network adapter",
+							ArgsUsage: "[vm] [mac address or index]",
+							Flags: []cli.Flag{
+								cli.StringFlag{
+									Name:        "network, net",
+									Usage:       "Network to attach, get names from 'qvscli net list'",
+									Destination: &nicNetwork,
+								},
+								cli.StringFlag{
+									Name:        "model",
+									Usage:       "Adapter model: " + strings.Join(nicModels, ", "),
+									Destination: &nicModel,
+								},
+								cli.IntFlag{
+									Name:        "vlan",
+									Usage:       "VLAN ID, 0 for untagged",
+									Destination: &nicVLAN,
+								},
+							},
+							Action: func(c *cli.Context) error {
+								client := getClient()
+								vm, err := client.VMGet(c.Args().Get(0))
 								if err != nil {
 									return err
 								}
-								_, err = mf.WriteString(fmt.Sprintf(DefaultMetaData, name, ts, name))
+								a, err := findVMAdapter(vm, c.Args().Get(1))
 								if err != nil {
 									return err
 								}
-								mf.Close()
-							}
-
-							if userDataFile == "" {
-								authKeyData, err := ioutil.ReadFile(vmAuthorizedKey)
+								if err := validateNICModel(nicModel); err != nil {
+									return err
+								}
+								if err := validateVLAN(nicVLAN); err != nil {
+									return err
+								}
+								changes := make(map[string]string)
+								if nicNetwork != "" {
+									if changes["bridge"], err = client.QVSNetName(nicNetwork); err != nil {
+										return err
+									}
+								}
+								if nicModel != "" {
+									changes["model"] = nicModel
+								}
+								if c.IsSet("vlan") {
+									changes["vlan"] = fmt.Sprintf("%d", nicVLAN)
+								}
+								if len(changes) == 0 {
+									return fmt.Errorf("nothing to change, use --network, --model and/or --vlan")
+								}
+								if err := client.VMAdapterUpdate(fmt.Sprintf("%d", vm.ID), a.ID, changes); err != nil {
+									return err
+								}
+								log.Printf("INFO: Updated network adapter %s of VM: %s", a.MAC, vm.Name)
+								return nil
+							},
+						},
+					},
+				},
+				{
+					Name:    "cdrom",
+					Aliases: []string{"cd"},
+					Usage:   "options for VM CD-ROM drives",
+					Subcommands: []cli.Command{
+						{
+							Name:      "list",
+							Aliases:   []string{"ls"},
+							Usage:     "list CD-ROM drives of a VM by ID or name",
+							ArgsUsage: "[vm]",
+							Flags: []cli.Flag{
+								cli.StringFlag{
+									Name:        "output, o",
+									Usage:       "Output format, text or json",
+									Value:       "text",
+									Destination: &outputFormat,
+								},
+							},
+							Action: func(c *cli.Context) error {
+								client := getClient()
+								vm, err := client.VMGet(c.Args().First())
 								if err != nil {
-									return fmt.Errorf("could not generate user-data, error reading %s and --authorized-key not provided, %v", vmAuthorizedKey, err)
+									return err
 								}
-								userDataFile = filepath.Join(dir, fmt.Sprintf("user-data"))
-								uf, err := os.OpenFile(userDataFile, os.O_RDWR|os.O_CREATE, 0644)
+								if outputFormat == "json" {
+									pretty, _ := json.MarshalIndent(vm.CDROMs, "", "  ")
+									fmt.Println(string(pretty))
+								} else if outputFormat == "text" {
+									w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', 0)
+									fmt.Fprintln(w, "INDEX\tBOOT ORDER\tISO")
+									for i, cd := range vm.CDROMs {
+										iso := cd.Path
+										if iso == "" {
+											iso = "(empty)"
+										}
+										fmt.Fprintln(w, strings.Join([]string{
+											fmt.Sprintf("%d", i),
+											fmt.Sprintf("%d", cd.BootOrder),
+											iso,
+										}, "\t"))
+									}
+									w.Flush()
+								} else {
+									return fmt.Errorf("invalid output format %s", outputFormat)
+								}
+								return nil
+							},
+						},
+						{
+							Name:      "insert",
+							Usage:     "insert a NAS ISO, or a local ISO that is uploaded first, into a CD-ROM drive",
+							ArgsUsage: "[vm] [iso path]",
+							Flags: []cli.Flag{
+								cli.IntFlag{
+									Name:        "index",
+									Usage:       "Index of the CD-ROM drive, a new drive is added when the index equals the number of drives",
+									Destination: &cdromIndex,
+								},
+							},
+							Action: func(c *cli.Context) error {
+								client := getClient()
+								vm, err := client.VMGet(c.Args().Get(0))
 								if err != nil {
 									return err
 								}
-
-								// Generate SSH password
-								vmSSHPassword, err := password.Generate(8, 2, 0, false, false)
-								log.Printf("Your SSH password is: %s", vmSSHPassword)
-
-								// Generate user-data from template
-								t, _ := template.New("user-data").Funcs(sprig.TxtFuncMap()).Parse(DefaultUserDataTemplate)
-								type tmplData struct {
-									Hostname      string
-									LocalLogin    bool
-									LoginPassword string
-									StartupScript string
-									AuthorizedKey string
+								if c.Args().Get(1) == "" {
+									return fmt.Errorf("no ISO path provided")
 								}
-
-								// Read startup-script file, if defined.
-								var startupScript []byte
-								_, err = os.Stat(vmStartupScript)
-								if err == nil {
-									startupScript, err = ioutil.ReadFile(vmStartupScript)
+								isoPath, err := client.ResolveISO(c.Args().Get(1), filepath.Join(qvsDisksDir, vm.Name))
+								if err != nil {
+									return err
+								}
+								id := fmt.Sprintf("%d", vm.ID)
+								if cdromIndex == len(vm.CDROMs) {
+									if err := requireStopped(vm, "add a CD-ROM drive"); err != nil {
+										return err
+									}
+									if err := client.VMCDROMAdd(id, map[string]string{"path": isoPath}); err != nil {
+										return err
+									}
+								} else {
+									cd, err := findVMCDROM(vm, cdromIndex)
 									if err != nil {
 										return err
 									}
+									if err := client.VMCDROMUpdate(id, cd.ID, map[string]string{"path": isoPath}); err != nil {
+										return err
+									}
 								}
-
-								data := tmplData{
-									Hostname:      name,
-									LocalLogin:    !vmNoLocalLogin,
-									LoginPassword: vmSSHPassword,
-									StartupScript: string(startupScript),
-									AuthorizedKey: string(authKeyData),
-								}
-								if err = t.Execute(uf, data); err != nil {
+								log.Printf("INFO: Inserted %s into CD-ROM %d of VM: %s", isoPath, cdromIndex, vm.Name)
+								return nil
+							},
+						},
+						{
+							Name:      "eject",
+							Usage:     "eject the ISO from a CD-ROM drive",
+							ArgsUsage: "[vm]",
+							Flags: []cli.Flag{
+								cli.IntFlag{
+									Name:        "index",
+									Usage:       "Index of the CD-ROM drive",
+									Destination: &cdromIndex,
+								},
+								cli.BoolFlag{
+									Name:        "delete-file",
+									Usage:       "Also delete the ejected ISO from the NAS",
+									Destination: &cdromDeleteFile,
+								},
+							},
+							Action: func(c *cli.Context) error {
+								client := getClient()
+								vm, err := client.VMGet(c.Args().Get(0))
+								if err != nil {
 									return err
 								}
-								uf.Close()
-							}
-
-							if _, err := os.Stat(userDataFile); os.IsNotExist(err) {
-								return fmt.Errorf("user-data file does not exist: %s", userDataFile)
-							}
-							if _, err := os.Stat(metaDataFile); os.IsNotExist(err) {
-								return fmt.Errorf("meta-data file does not exist: %s", metaDataFile)
-							}
-							if err = makeConfigISO(metadataISOFile, metaDataFile, userDataFile); err != nil {
-								return err
-							}
-							metadataISODest = filepath.Join(qvsDisksDir, name, filepath.Base(metadataISOFile))
-						}
-
-						// Check for existing folder
-						files, err := client.ListDir(qvsDisksDir)
-						if err != nil {
-							return err
-						}
-						found = false
-						for _, qf := range files {
-							if qf.Filename == name {
-								found = true
-								break
-							}
-						}
-
-						if vmDescription == "" {
-							vmDescription = fmt.Sprintf("Created with qvscli at %s", now.Format("20060102150405"))
-						}
-
-						// Create directory for VM disk
-						if found == false {
-							log.Printf("INFO: Creating directory on NAS for VM: %s", filepath.Dir(metadataISODest))
-							if err := client.CreateDir(filepath.Dir(metadataISODest)); err != nil {
-								return err
-							}
-						}
-
-						if metadataISODest != "" {
-							f, err := os.Open(metadataISOFile)
-							if err != nil {
-								return err
-							}
-
-							log.Printf("INFO: Uploading metadata ISO image to NAS: %s\n", metadataISODest)
-							if err := client.UploadFile(f, metadataISODest); err != nil {
-								return err
-							}
-						}
-
-						// Remote copy image to VM disk directory
-						vmImageDest := filepath.Join(qvsDisksDir, name, filepath.Base(vmImage))
-						vmBootDiskFile := fmt.Sprintf("boot_disk_%d.img", ts)
-						vmImagePath := filepath.Join(filepath.Dir(vmImageDest), vmBootDiskFile)
-
-						log.Printf("INFO: Remote copy VM image %s -> %s", vmImageSrc, vmImagePath)
-						if err := client.CopyFile(vmImageSrc, vmImageDest); err != nil {
-							return err
-						}
-						if err := client.RenameFile(filepath.Dir(vmImageDest), filepath.Base(vmImageDest), vmBootDiskFile); err != nil {
-							return err
-						}
-
-						// Generate VNC Password if not given
-						if vmVNCPassword == "" {
-							// Generate a password that is 8 characters long with 3 digits, 0 symbols,
-							// allowing upper and lower case letters, disallowing repeat characters.
-							vmVNCPassword, err = password.Generate(8, 2, 0, false, false)
-							log.Printf("Your VNC password is: %s", vmVNCPassword)
-						}
-
-						// Create VM
-						if err := client.VMCreate(name, vmDescription, "linux", vmCores, vmMemoryGB, vmNetName, vmMACAddress, metadataISODest, vmImagePath, vmVNCPassword); err != nil {
-							return err
-						}
-						log.Printf("INFO: VM Created: %s.", name)
-
-						// Start VM
-						if vmNoStart {
-							return fmt.Errorf("WARN: not starting newly created vm because --no-start flag was passed. To start VM, run: 'qvscli vm start %s", name)
-						} else {
-							id, err := client.VMGetID(name)
-							if err != nil {
-								return err
-							}
-							if err := client.VMStart(id); err != nil {
-								return err
-							} else {
-								v, err := client.VMGet(name)
+								cd, err := findVMCDROM(vm, cdromIndex)
 								if err != nil {
 									return err
 								}
-								log.Printf("INFO: VM started. VNC port: %d", v.Graphics[0].Port)
-							}
-						}
-						return nil
+								if cd.Path == "" {
+									return fmt.Errorf("CD-ROM %d of VM %s is empty", cdromIndex, vm.Name)
+								}
+								if err := client.VMCDROMUpdate(fmt.Sprintf("%d", vm.ID), cd.ID, map[string]string{"path": ""}); err != nil {
+									return err
+								}
+								log.Printf("INFO: Ejected %s from VM: %s", cd.Path, vm.Name)
+
+								if cdromDeleteFile {
+									if err := client.DeleteFile(cd.Path); err != nil {
+										return err
+									}
+									log.Printf("INFO: Deleted ISO: %s", cd.Path)
+								}
+								return nil
+							},
+						},
 					},
 				},
 				{
@@ -789,10 +3455,10 @@ func main() {
 								for _, f := range snapFiles {
 									// ts := time.Unix(f.EpochMT, 0)
 									if f.IsFolder == 0 {
-										fmt.Fprintf(w, strings.Join([]string{
+										fmt.Fprintln(w, strings.Join([]string{
 											f.Filename,
 											f.MT,
-										}, "\t")+"\n")
+										}, "\t"))
 									}
 								}
 								w.Flush()
@@ -846,8 +3512,12 @@ func main() {
 
 								for _, f := range snapFiles {
 									if filepath.Base(f.Filename) == snapFile {
+										snapPath := filepath.Join(snapDir, f.Filename)
+										if err := checkImageUnused(client, snapPath); err != nil {
+											return err
+										}
 										log.Printf("Deleting snapshot file: %s", f.Filename)
-										return client.DeleteFile(filepath.Join(snapDir, f.Filename))
+										return client.DeleteFile(snapPath)
 									}
 								}
 
@@ -868,3 +3538,79 @@ func main() {
 		log.Fatal(err)
 	}
 }
+
+// validateVNCPassword checks the password fits the 8 bytes of the VNC DES key.
+func validateVNCPassword(p string) error {
+	if len(p) == 0 || len(p) > 8 {
+		return fmt.Errorf("invalid VNC password, it must be 1 to 8 characters long, got %d", len(p))
+	}
+	for _, r := range p {
+		if r < 0x21 || r > 0x7e {
+			return fmt.Errorf("invalid VNC password, only printable ASCII characters without spaces are allowed")
+		}
+	}
+	return nil
+}
+
+// storeVMSecrets saves the credentials generated for a newly created VM in the secrets store.
+// Passwords are only logged when print is set.
+func storeVMSecrets(client *QVSClient, secrets *SecretsStore, name string, fields map[string]string, print bool) error {
+	if len(fields) == 0 {
+		return nil
+	}
+	vm, err := client.VMGet(name)
+	if err != nil {
+		return err
+	}
+	for f, v := range fields {
+		secrets.Set(vm.UUID, f, v)
+	}
+	if err := secrets.Save(); err != nil {
+		return err
+	}
+	if !print {
+		log.Printf("INFO: Credentials of VM %s saved to %s, show them with 'qvscli secrets get %s'", name, secrets.path, name)
+		return nil
+	}
+	if p, ok := fields[secretLoginPassword]; ok {
+		log.Printf("Your login password is: %s", p)
+	}
+	if p, ok := fields[secretVNCPassword]; ok {
+		log.Printf("Your VNC password is: %s", p)
+	}
+	return nil
+}
+
+func validateVMName(name string) error {
+	nameRegex := regexp.MustCompile(`^[[:alnum:]][[:alnum:]\-]{0,61}[[:alnum:]]|[[:alpha:]]$`)
+	if !nameRegex.MatchString(name) {
+		return fmt.Errorf("invalid instance name: %s", name)
+	}
+	return nil
+}
+
+// parseMemory parses a memory size like 8G or 512M, a plain number is taken as Gigabytes.
+func parseMemory(s string) (uint64, error) {
+	if n, err := strconv.Atoi(strings.TrimSpace(s)); err == nil {
+		if n < 1 {
+			return 0, fmt.Errorf("invalid memory size: %s", s)
+		}
+		return uint64(n) << 30, nil
+	}
+	return parseSize(s)
+}
+
+func checkImageUnused(client *QVSClient, imagePath string) error {
+	refs, err := client.ImageReferences(imagePath)
+	if err != nil {
+		return err
+	}
+	if len(refs) > 0 {
+		var names []string
+		for _, v := range refs {
+			names = append(names, v.Name)
+		}
+		return fmt.Errorf("image %s is the backing file of linked disks for VMs: %s", imagePath, strings.Join(names, ", "))
+	}
+	return nil
+}
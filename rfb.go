@@ -0,0 +1,325 @@
+package main
+
+import (
+	"crypto/des"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"io/ioutil"
+	"net"
+	"strconv"
+)
+
+// RFB (VNC) protocol, RFC 6143.
+const rfbVersion38 = "RFB 003.008\n"
+
+const (
+	rfbSecInvalid = 0
+	rfbSecNone    = 1
+	rfbSecVNCAuth = 2
+)
+
+// rfbReadVersion reads a ProtocolVersion message and returns the minor version.
+func rfbReadVersion(r io.Reader) (int, error) {
+	buf := make([]byte, 12)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return 0, err
+	}
+	var major, minor int
+	if _, err := fmt.Sscanf(string(buf), "RFB %03d.%03d\n", &major, &minor); err != nil || major != 3 {
+		return 0, fmt.Errorf("unsupported RFB protocol version: %s", strconv.Quote(string(buf)))
+	}
+	return minor, nil
+}
+
+func rfbReadReason(r io.Reader) string {
+	var n uint32
+	if err := binary.Read(r, binary.BigEndian, &n); err != nil || n > 4096 {
+		return "unknown reason"
+	}
+	reason := make([]byte, n)
+	io.ReadFull(r, reason)
+	return string(reason)
+}
+
+// rfbClientHandshake runs the version and security handshake against a VNC server,
+// authenticating with password if the server asks for it.
+func rfbClientHandshake(rw io.ReadWriter, password string) error {
+	minor, err := rfbReadVersion(rw)
+	if err != nil {
+		return err
+	}
+	if minor >= 8 {
+		minor = 8
+	} else if minor >= 7 {
+		minor = 7
+	} else {
+		minor = 3
+	}
+	if _, err := fmt.Fprintf(rw, "RFB 003.%03d\n", minor); err != nil {
+		return err
+	}
+
+	var secType uint32
+	if minor == 3 {
+		if err := binary.Read(rw, binary.BigEndian, &secType); err != nil {
+			return err
+		}
+	} else {
+		var n uint8
+		if err := binary.Read(rw, binary.BigEndian, &n); err != nil {
+			return err
+		}
+		if n == 0 {
+			return fmt.Errorf("VNC server refused the connection: %s", rfbReadReason(rw))
+		}
+		types := make([]byte, n)
+		if _, err := io.ReadFull(rw, types); err != nil {
+			return err
+		}
+		for _, t := range types {
+			if t == rfbSecNone || (t == rfbSecVNCAuth && secType != rfbSecNone) {
+				secType = uint32(t)
+			}
+		}
+		if secType == rfbSecInvalid {
+			return fmt.Errorf("VNC server does not support password or no authentication, offered security types: %v", types)
+		}
+		if _, err := rw.Write([]byte{byte(secType)}); err != nil {
+			return err
+		}
+	}
+
+	switch secType {
+	case rfbSecInvalid:
+		return fmt.Errorf("VNC server refused the connection: %s", rfbReadReason(rw))
+	case rfbSecNone:
+		if minor < 8 {
+			return nil
+		}
+	case rfbSecVNCAuth:
+		if password == "" {
+			return fmt.Errorf("VNC server requires a password")
+		}
+		challenge := make([]byte, 16)
+		if _, err := io.ReadFull(rw, challenge); err != nil {
+			return err
+		}
+		resp, err := vncAuthResponse(challenge, password)
+		if err != nil {
+			return err
+		}
+		if _, err := rw.Write(resp); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unsupported VNC security type: %d", secType)
+	}
+
+	var result uint32
+	if err := binary.Read(rw, binary.BigEndian, &result); err != nil {
+		return err
+	}
+	if result != 0 {
+		reason := "wrong VNC password?"
+		if minor >= 8 {
+			reason = rfbReadReason(rw)
+		}
+		return fmt.Errorf("VNC authentication failed: %s", reason)
+	}
+	return nil
+}
+
+// rfbServerHandshake runs the version and security handshake with a VNC viewer, offering no authentication.
+func rfbServerHandshake(rw io.ReadWriter) error {
+	if _, err := io.WriteString(rw, rfbVersion38); err != nil {
+		return err
+	}
+	minor, err := rfbReadVersion(rw)
+	if err != nil {
+		return err
+	}
+	if minor < 7 {
+		return binary.Write(rw, binary.BigEndian, uint32(rfbSecNone))
+	}
+	if _, err := rw.Write([]byte{1, rfbSecNone}); err != nil {
+		return err
+	}
+	choice := make([]byte, 1)
+	if _, err := io.ReadFull(rw, choice); err != nil {
+		return err
+	}
+	if choice[0] != rfbSecNone {
+		return fmt.Errorf("VNC viewer chose unsupported security type %d", choice[0])
+	}
+	if minor >= 8 {
+		return binary.Write(rw, binary.BigEndian, uint32(0))
+	}
+	return nil
+}
+
+// vncAuthResponse encrypts the server challenge with the password as DES key. VNC uses the password
+// bytes with their bits reversed, truncated or zero padded to 8 bytes.
+func vncAuthResponse(challenge []byte, password string) ([]byte, error) {
+	key := make([]byte, 8)
+	copy(key, password)
+	for i, b := range key {
+		var r byte
+		for j := uint(0); j < 8; j++ {
+			r |= ((b >> j) & 1) << (7 - j)
+		}
+		key[i] = r
+	}
+	block, err := des.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	resp := make([]byte, len(challenge))
+	for i := 0; i+8 <= len(challenge); i += 8 {
+		block.Encrypt(resp[i:i+8], challenge[i:i+8])
+	}
+	return resp, nil
+}
+
+// RFBClient is a minimal VNC client that receives the framebuffer as raw 32 bit true colour pixels.
+type RFBClient struct {
+	conn   net.Conn
+	Width  int
+	Height int
+	Name   string
+}
+
+const (
+	rfbMsgFramebufferUpdate   = 0
+	rfbMsgSetColourMapEntries = 1
+	rfbMsgBell                = 2
+	rfbMsgServerCutText       = 3
+)
+
+const rfbEncodingRaw = 0
+
+// newRFBClient authenticates on conn and initializes a shared session.
+func newRFBClient(conn net.Conn, password string) (*RFBClient, error) {
+	if err := rfbClientHandshake(conn, password); err != nil {
+		return nil, err
+	}
+	// ClientInit, shared so other viewers stay connected.
+	if _, err := conn.Write([]byte{1}); err != nil {
+		return nil, err
+	}
+	var init struct {
+		Width, Height uint16
+		PixelFormat   [16]byte
+		NameLength    uint32
+	}
+	if err := binary.Read(conn, binary.BigEndian, &init); err != nil {
+		return nil, err
+	}
+	name := make([]byte, init.NameLength)
+	if _, err := io.ReadFull(conn, name); err != nil {
+		return nil, err
+	}
+	r := &RFBClient{conn: conn, Width: int(init.Width), Height: int(init.Height), Name: string(name)}
+
+	// SetPixelFormat: 32 bpp, depth 24, little endian, true colour, red at bit 16, green at 8, blue at 0.
+	msg := []byte{0, 0, 0, 0, 32, 24, 0, 1, 0, 255, 0, 255, 0, 255, 16, 8, 0, 0, 0, 0}
+	if _, err := conn.Write(msg); err != nil {
+		return nil, err
+	}
+	// SetEncodings: raw only.
+	setEncodings := struct {
+		Type      uint8
+		Padding   uint8
+		Count     uint16
+		Encodings [1]int32
+	}{2, 0, 1, [1]int32{rfbEncodingRaw}}
+	if err := binary.Write(conn, binary.BigEndian, setEncodings); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *RFBClient) Close() error {
+	return r.conn.Close()
+}
+
+// Screenshot requests a full framebuffer update and returns it as an image.
+func (r *RFBClient) Screenshot() (*image.RGBA, error) {
+	req := struct {
+		Type          uint8
+		Incremental   uint8
+		X, Y          uint16
+		Width, Height uint16
+	}{3, 0, 0, 0, uint16(r.Width), uint16(r.Height)}
+	if err := binary.Write(r.conn, binary.BigEndian, req); err != nil {
+		return nil, err
+	}
+	img := image.NewRGBA(image.Rect(0, 0, r.Width, r.Height))
+	for {
+		msgType := make([]byte, 1)
+		if _, err := io.ReadFull(r.conn, msgType); err != nil {
+			return nil, err
+		}
+		switch msgType[0] {
+		case rfbMsgFramebufferUpdate:
+			var hdr struct {
+				Padding uint8
+				Rects   uint16
+			}
+			if err := binary.Read(r.conn, binary.BigEndian, &hdr); err != nil {
+				return nil, err
+			}
+			for i := 0; i < int(hdr.Rects); i++ {
+				var rect struct {
+					X, Y, W, H uint16
+					Encoding   int32
+				}
+				if err := binary.Read(r.conn, binary.BigEndian, &rect); err != nil {
+					return nil, err
+				}
+				if rect.Encoding != rfbEncodingRaw {
+					return nil, fmt.Errorf("unsupported VNC encoding: %d", rect.Encoding)
+				}
+				pixels := make([]byte, int(rect.W)*int(rect.H)*4)
+				if _, err := io.ReadFull(r.conn, pixels); err != nil {
+					return nil, err
+				}
+				for y := 0; y < int(rect.H); y++ {
+					for x := 0; x < int(rect.W); x++ {
+						p := pixels[(y*int(rect.W)+x)*4:]
+						img.SetRGBA(int(rect.X)+x, int(rect.Y)+y, color.RGBA{R: p[2], G: p[1], B: p[0], A: 255})
+					}
+				}
+			}
+			return img, nil
+		case rfbMsgSetColourMapEntries:
+			var hdr struct {
+				Padding    uint8
+				FirstColor uint16
+				Colors     uint16
+			}
+			if err := binary.Read(r.conn, binary.BigEndian, &hdr); err != nil {
+				return nil, err
+			}
+			if _, err := io.CopyN(ioutil.Discard, r.conn, int64(hdr.Colors)*6); err != nil {
+				return nil, err
+			}
+		case rfbMsgBell:
+		case rfbMsgServerCutText:
+			var hdr struct {
+				Padding [3]uint8
+				Length  uint32
+			}
+			if err := binary.Read(r.conn, binary.BigEndian, &hdr); err != nil {
+				return nil, err
+			}
+			if _, err := io.CopyN(ioutil.Discard, r.conn, int64(hdr.Length)); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("unexpected VNC server message type: %d", msgType[0])
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/howeyc/gopass"
+	"golang.org/x/crypto/scrypt"
+)
+
+// secretsPassphraseEnv holds the passphrase of the secrets store for non-interactive use.
+const secretsPassphraseEnv = "QVSCLI_SECRETS_PASSPHRASE"
+
+// Fields of the per-VM entries in the secrets store.
+const (
+	secretLoginPassword = "login_password"
+	secretVNCPassword   = "vnc_password"
+	secretSSHPrivateKey = "ssh_private_key"
+	secretSSHPublicKey  = "ssh_public_key"
+)
+
+// scrypt parameters recommended for interactive logins.
+const (
+	secretsScryptN = 1 << 15
+	secretsScryptR = 8
+	secretsScryptP = 1
+)
+
+// PassphraseFunc returns the passphrase of the secrets store, confirm is set when a new store is created.
+type PassphraseFunc func(confirm bool) ([]byte, error)
+
+// SecretsStore keeps credentials generated by qvscli on the local machine, keyed by VM UUID.
+// The file is encrypted with AES-256-GCM, the key is derived from a passphrase with scrypt.
+type SecretsStore struct {
+	path       string
+	passphrase []byte
+	getPass    PassphraseFunc
+	Entries    map[string]map[string]string `json:"entries"`
+}
+
+type secretsEnvelope struct {
+	Version int    `json:"version"`
+	Salt    []byte `json:"salt"`
+	Nonce   []byte `json:"nonce"`
+	Data    []byte `json:"data"`
+}
+
+// loadSecrets reads the secrets store at path, a missing file is an empty store.
+// The passphrase is only asked for when the store is read or written.
+func loadSecrets(path string, getPass PassphraseFunc) (*SecretsStore, error) {
+	s := &SecretsStore{path: path, getPass: getPass, Entries: make(map[string]map[string]string)}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var env secretsEnvelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return nil, fmt.Errorf("error reading secrets store %s: %v", path, err)
+	}
+	if env.Version != 1 {
+		return nil, fmt.Errorf("unsupported secrets store version %d in %s", env.Version, path)
+	}
+	if s.passphrase, err = getPass(false); err != nil {
+		return nil, err
+	}
+	gcm, err := secretsCipher(s.passphrase, env.Salt)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := gcm.Open(nil, env.Nonce, env.Data, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not decrypt secrets store %s, wrong passphrase?", path)
+	}
+	if err := json.Unmarshal(plain, s); err != nil {
+		return nil, err
+	}
+	if s.Entries == nil {
+		s.Entries = make(map[string]map[string]string)
+	}
+	return s, nil
+}
+
+// promptPassphrase reads the secrets store passphrase from the environment or the terminal.
+func promptPassphrase(confirm bool) ([]byte, error) {
+	if p := os.Getenv(secretsPassphraseEnv); p != "" {
+		return []byte(p), nil
+	}
+	prompt := "Enter secrets store passphrase: "
+	if confirm {
+		prompt = "Enter a passphrase for the new secrets store: "
+	}
+	p, err := gopass.GetPasswdPrompt(prompt, false, os.Stdin, os.Stderr)
+	if err != nil {
+		return nil, fmt.Errorf("error reading passphrase, set %s for non-interactive use: %v", secretsPassphraseEnv, err)
+	}
+	if len(p) == 0 {
+		return nil, fmt.Errorf("no secrets store passphrase provided")
+	}
+	if confirm {
+		again, err := gopass.GetPasswdPrompt("Confirm passphrase: ", false, os.Stdin, os.Stderr)
+		if err != nil {
+			return nil, err
+		}
+		if string(again) != string(p) {
+			return nil, fmt.Errorf("passphrases do not match")
+		}
+	}
+	return p, nil
+}
+
+func secretsCipher(passphrase, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key(passphrase, salt, secretsScryptN, secretsScryptR, secretsScryptP, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Unlock asks for the passphrase of a new store, so that a later Save does not prompt.
+func (s *SecretsStore) Unlock() error {
+	if s.passphrase != nil {
+		return nil
+	}
+	var err error
+	s.passphrase, err = s.getPass(true)
+	return err
+}
+
+func (s *SecretsStore) Save() error {
+	if err := s.Unlock(); err != nil {
+		return err
+	}
+	plain, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	env := secretsEnvelope{Version: 1, Salt: make([]byte, 16)}
+	if _, err := rand.Read(env.Salt); err != nil {
+		return err
+	}
+	gcm, err := secretsCipher(s.passphrase, env.Salt)
+	if err != nil {
+		return err
+	}
+	env.Nonce = make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(env.Nonce); err != nil {
+		return err
+	}
+	env.Data = gcm.Seal(nil, env.Nonce, plain, nil)
+	data, err := json.MarshalIndent(&env, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return err
+	}
+
+	// Replace the store atomically so an interrupted write does not lose all entries.
+	tmp := s.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+func (s *SecretsStore) Get(uuid, field string) string {
+	return s.Entries[uuid][field]
+}
+
+func (s *SecretsStore) Set(uuid, field, value string) {
+	if s.Entries[uuid] == nil {
+		s.Entries[uuid] = make(map[string]string)
+	}
+	s.Entries[uuid][field] = value
+}
+
+// Unset removes a field, and the entry of the VM once it is empty.
+func (s *SecretsStore) Unset(uuid, field string) {
+	delete(s.Entries[uuid], field)
+	if len(s.Entries[uuid]) == 0 {
+		delete(s.Entries, uuid)
+	}
+}
+
+func (s *SecretsStore) Delete(uuid string) {
+	delete(s.Entries, uuid)
+}
+
+// Fields returns the sorted field names stored for a VM.
+func (s *SecretsStore) Fields(uuid string) []string {
+	var fields []string
+	for f := range s.Entries[uuid] {
+		fields = append(fields, f)
+	}
+	sort.Strings(fields)
+	return fields
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func testPassphrase(p string) PassphraseFunc {
+	return func(confirm bool) ([]byte, error) {
+		return []byte(p), nil
+	}
+}
+
+func TestSecretsStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "qvs-secrets-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "secrets.json")
+
+	tests := []struct {
+		name     string
+		existing string
+		pass     string
+		wantErr  bool
+		want     string
+	}{
+		{name: "missing store", pass: "pw"},
+		{name: "encrypted store", pass: "pw", want: "abc"},
+		{name: "wrong passphrase", pass: "other", wantErr: true},
+		{name: "plaintext store", existing: `{"entries": {"uuid-1": {"vnc_password": "abc"}}}`, pass: "pw", wantErr: true},
+		{name: "unknown version", existing: `{"version": 2}`, pass: "pw", wantErr: true},
+	}
+	for _, tt := range tests {
+		if tt.existing != "" {
+			if err := ioutil.WriteFile(path, []byte(tt.existing), 0600); err != nil {
+				t.Fatal(err)
+			}
+		}
+		s, err := loadSecrets(path, testPassphrase(tt.pass))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if tt.wantErr {
+			continue
+		}
+		if got := s.Get("uuid-1", secretVNCPassword); got != tt.want {
+			t.Errorf("%s: vnc_password = %q, want %q", tt.name, got, tt.want)
+		}
+		if tt.want == "" {
+			s.Set("uuid-1", secretVNCPassword, "abc")
+			if err := s.Save(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		data, _ := ioutil.ReadFile(path)
+		var env secretsEnvelope
+		if json.Unmarshal(data, &env) != nil || env.Version != 1 || bytes.Contains(data, []byte("abc")) {
+			t.Errorf("%s: store is not encrypted: %s", tt.name, data)
+		}
+	}
+}
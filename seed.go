@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"text/template"
+
+	"github.com/Masterminds/sprig"
+)
+
+type SeedConfig struct {
+	Name              string
+	TS                int64
+	MetaDataFile      string
+	UserDataFile      string
+	NetworkConfigFile string
+	AuthorizedKeyFile string
+	StartupScriptFile string
+	LocalLogin        bool
+	LoginPassword     string
+	SSHImportIDs      []string
+	Users             []CloudUser
+	GrowRoot          bool
+	DataDisks         []DataDisk
+	NICs              []NIC
+	Image             string
+	Values            map[string]interface{}
+	Partials          []string
+	// Template renders the user supplied files as templates, otherwise they are copied as is.
+	Template bool
+}
+
+// writeSeedFile writes the user supplied src to dst, rendered as a template if cfg.Template is set.
+func writeSeedFile(src, dst string, cfg SeedConfig, data SeedTemplateData) error {
+	if !cfg.Template {
+		return copyFile(src, dst)
+	}
+	return renderTemplateFile(src, dst, cfg.Partials, data)
+}
+
+// makeSeedISO generates the cloud-init meta-data, user-data and network-config in dir, or uses the files
+// provided, and packs them into a NoCloud seed ISO. Returns the path of the ISO.
+func makeSeedISO(dir string, cfg SeedConfig) (string, error) {
+	metadataISOFile := filepath.Join(dir, fmt.Sprintf("metadata_%d.iso", cfg.TS))
+	seedData := newSeedTemplateData(cfg)
+
+	metaDataFile := cfg.MetaDataFile
+	if metaDataFile != "" {
+		if _, err := os.Stat(metaDataFile); os.IsNotExist(err) {
+			return "", fmt.Errorf("meta-data file does not exist: %s", metaDataFile)
+		}
+		metaDataFile = filepath.Join(dir, "meta-data")
+		if err := writeSeedFile(cfg.MetaDataFile, metaDataFile, cfg, seedData); err != nil {
+			return "", err
+		}
+	} else {
+		metaDataFile = filepath.Join(dir, "meta-data")
+		if err := ioutil.WriteFile(metaDataFile, []byte(fmt.Sprintf(DefaultMetaData, cfg.Name, cfg.TS, cfg.Name)), 0644); err != nil {
+			return "", err
+		}
+	}
+
+	userDataFile := cfg.UserDataFile
+	if userDataFile != "" && len(cfg.DataDisks) > 0 {
+		log.Printf("WARN: --user-data provided, data disks will not be formatted or mounted by cloud-init.")
+	}
+	if userDataFile != "" && (len(cfg.Users) > 0 || len(cfg.SSHImportIDs) > 0) {
+		log.Printf("WARN: --user-data provided, additional users and SSH import IDs are ignored.")
+	}
+
+	if userDataFile != "" {
+		if _, err := os.Stat(userDataFile); os.IsNotExist(err) {
+			return "", fmt.Errorf("user-data file does not exist: %s", userDataFile)
+		}
+		userDataFile = filepath.Join(dir, "user-data")
+		if err := writeSeedFile(cfg.UserDataFile, userDataFile, cfg, seedData); err != nil {
+			return "", err
+		}
+	} else {
+		authKeys, err := readAuthorizedKeys(cfg.AuthorizedKeyFile)
+		if err != nil {
+			return "", fmt.Errorf("could not generate user-data, error reading %s and --authorized-key not provided, %v", cfg.AuthorizedKeyFile, err)
+		}
+		userDataFile = filepath.Join(dir, "user-data")
+		uf, err := os.OpenFile(userDataFile, os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			return "", err
+		}
+		defer uf.Close()
+
+		// Generate user-data from template
+		t, _ := template.New("user-data").Funcs(sprig.TxtFuncMap()).Parse(DefaultUserDataTemplate)
+		type tmplData struct {
+			Hostname          string
+			LocalLogin        bool
+			LoginPasswordHash string
+			StartupScript     string
+			AuthorizedKeys    []string
+			SSHImportIDs      []string
+			Users             []CloudUser
+			GrowRoot          bool
+			DataDisks         []DataDisk
+		}
+
+		// Read startup-script file, if defined.
+		var startupScript []byte
+		if _, err := os.Stat(cfg.StartupScriptFile); err == nil {
+			startupScript, err = ioutil.ReadFile(cfg.StartupScriptFile)
+			if err != nil {
+				return "", err
+			}
+		}
+
+		// Only the hash of the login password ends up on the ISO.
+		passwordHash := ""
+		if cfg.LocalLogin {
+			if passwordHash, err = hashPassword(cfg.LoginPassword); err != nil {
+				return "", err
+			}
+		}
+
+		data := tmplData{
+			Hostname:          cfg.Name,
+			LocalLogin:        cfg.LocalLogin,
+			LoginPasswordHash: passwordHash,
+			StartupScript:     string(startupScript),
+			AuthorizedKeys:    authKeys,
+			SSHImportIDs:      cfg.SSHImportIDs,
+			Users:             cfg.Users,
+			GrowRoot:          cfg.GrowRoot,
+			DataDisks:         cfg.DataDisks,
+		}
+		if err = t.Execute(uf, data); err != nil {
+			return "", err
+		}
+	}
+
+	// Without a network-config, cloud-init configures the first interface with DHCP.
+	networkConfigFile := cfg.NetworkConfigFile
+	if networkConfigFile == "" && needsNetworkConfig(cfg.NICs) {
+		networkConfigFile = filepath.Join(dir, "network-config")
+		nf, err := os.OpenFile(networkConfigFile, os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			return "", err
+		}
+		defer nf.Close()
+		if err := writeNetworkConfig(nf, cfg.NICs); err != nil {
+			return "", err
+		}
+	}
+	if cfg.NetworkConfigFile != "" {
+		if _, err := os.Stat(networkConfigFile); os.IsNotExist(err) {
+			return "", fmt.Errorf("network-config file does not exist: %s", networkConfigFile)
+		}
+		networkConfigFile = filepath.Join(dir, "network-config")
+		if err := writeSeedFile(cfg.NetworkConfigFile, networkConfigFile, cfg, seedData); err != nil {
+			return "", err
+		}
+	}
+
+	if _, err := os.Stat(userDataFile); os.IsNotExist(err) {
+		return "", fmt.Errorf("user-data file does not exist: %s", userDataFile)
+	}
+	if _, err := os.Stat(metaDataFile); os.IsNotExist(err) {
+		return "", fmt.Errorf("meta-data file does not exist: %s", metaDataFile)
+	}
+	if err := makeConfigISO(metadataISOFile, metaDataFile, userDataFile, networkConfigFile); err != nil {
+		return "", err
+	}
+	return metadataISOFile, nil
+}
+
+// needsNetworkConfig reports whether a NIC has settings that DHCP on the first interface does not give.
+func needsNetworkConfig(nics []NIC) bool {
+	for _, n := range nics {
+		if n.Static() || len(n.DNS) > 0 || len(n.Search) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// writeNetworkConfig renders the netplan network-config of nics to w.
+func writeNetworkConfig(w io.Writer, nics []NIC) error {
+	t, err := template.New("network-config").Funcs(sprig.TxtFuncMap()).Parse(DefaultNetworkConfigTemplate)
+	if err != nil {
+		return err
+	}
+	return t.Execute(w, struct{ NICs []NIC }{nics})
+}
+
+func (c *QVSClient) UploadSeedISO(metadataISOFile string, destDir string) (string, error) {
+	metadataISODest := filepath.Join(destDir, filepath.Base(metadataISOFile))
+	f, err := os.Open(metadataISOFile)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	log.Printf("INFO: Uploading metadata ISO image to NAS: %s\n", metadataISODest)
+	if err := c.UploadFile(f, metadataISODest); err != nil {
+		return "", err
+	}
+	return metadataISODest, nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNeedsNetworkConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		nics []NIC
+		want bool
+	}{
+		{"no NICs", nil, false},
+		{"DHCP", []NIC{{Network: "br0"}}, false},
+		{"static IP", []NIC{{Network: "br0"}, {Network: "br1", IP: "10.0.0.5/24"}}, true},
+		{"IP from pool", []NIC{{Network: "br0", IP: ipAuto}}, true},
+		{"DNS without IP", []NIC{{Network: "br0", DNS: []string{"10.0.0.2"}}}, true},
+		{"search without IP", []NIC{{Network: "br0", Search: []string{"lan"}}}, true},
+	}
+	for _, tt := range tests {
+		if got := needsNetworkConfig(tt.nics); got != tt.want {
+			t.Errorf("%s: needsNetworkConfig = %t, want %t", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestWriteNetworkConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		nics []NIC
+		want string
+	}{
+		{
+			name: "static IPv4 with gateway and DNS",
+			nics: []NIC{{MAC: "00:08:9B:AA:BB:CC", IP: "10.0.0.50/24", Gateway: "10.0.0.1", DNS: []string{"10.0.0.2", "10.0.0.3"}, Search: []string{"lan"}}},
+			want: `version: 2
+ethernets:
+  nic0:
+    match:
+      macaddress: "00:08:9b:aa:bb:cc"
+    addresses: [ "10.0.0.50/24" ]
+    routes:
+    - to: 0.0.0.0/0
+      via: 10.0.0.1
+    nameservers:
+      addresses: [ 10.0.0.2, 10.0.0.3 ]
+      search: [ lan ]
+`,
+		},
+		{
+			name: "DHCP with search domain and static IPv6",
+			nics: []NIC{{MAC: "00:08:9b:00:00:01", Search: []string{"example.com"}}, {MAC: "00:08:9b:00:00:02", IP: "fd00::10/64", Gateway: "fd00::1"}},
+			want: `version: 2
+ethernets:
+  nic0:
+    match:
+      macaddress: "00:08:9b:00:00:01"
+    dhcp4: true
+    nameservers:
+      search: [ example.com ]
+  nic1:
+    match:
+      macaddress: "00:08:9b:00:00:02"
+    addresses: [ "fd00::10/64" ]
+    routes:
+    - to: ::/0
+      via: fd00::1
+`,
+		},
+	}
+	for _, tt := range tests {
+		var b bytes.Buffer
+		if err := writeNetworkConfig(&b, tt.nics); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if b.String() != tt.want {
+			t.Errorf("%s: network-config =\n%s\nwant\n%s", tt.name, b.String(), tt.want)
+		}
+	}
+}
+
+func TestWriteSeedFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "qvscli-seed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	data := newSeedTemplateData(SeedConfig{Name: "web", NICs: []NIC{{MAC: "00:08:9b:aa:bb:cc", IP: "10.0.0.5/24"}}})
+
+	tests := []struct {
+		name     string
+		content  string
+		template bool
+		want     string
+	}{
+		{"copied as is", "#cloud-config\nhostname: {{ .Name }}\n", false, "#cloud-config\nhostname: {{ .Name }}\n"},
+		{"built-ins rendered", "#cloud-config\nhostname: {{ .Name }}\n", true, "#cloud-config\nhostname: web\n"},
+		{"meta-data built-ins rendered", "instance-id: {{ .Name }}-{{ .MAC }}\nlocal-ipv4: {{ .IP }}\n", true, "instance-id: web-00:08:9b:aa:bb:cc\nlocal-ipv4: 10.0.0.5\n"},
+	}
+	for _, tt := range tests {
+		src := filepath.Join(dir, "in")
+		dst := filepath.Join(dir, "out")
+		if err := ioutil.WriteFile(src, []byte(tt.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := writeSeedFile(src, dst, SeedConfig{Template: tt.template}, data); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		got, err := ioutil.ReadFile(dst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// Ctrl-], the detach key of telnet and virsh console.
+const serialEscapeChar = 0x1d
+
+const (
+	telnetSE   = 240
+	telnetSB   = 250
+	telnetWILL = 251
+	telnetDONT = 254
+	telnetIAC  = 255
+)
+
+// serialCreateRequest returns the create request of a serial-over-telnet device listening on port on the NAS.
+func serialCreateRequest(port int) map[string]string {
+	return map[string]string{
+		"type":     "tcp",
+		"port":     fmt.Sprintf("%d", port),
+		"protocol": "telnet",
+	}
+}
+
+// VMSerialDial connects to the serial console of a running VM, through a telnet serial device
+// if the VM has one, otherwise through the QVS console websocket.
+func (c *QVSClient) VMSerialDial(vm VMResponse, websocket bool) (io.ReadWriteCloser, error) {
+	if vm.PowerState != QVSPowerStateRunning {
+		return nil, fmt.Errorf("error, VM %s is not running, current state: %s", vm.Name, vm.PowerState)
+	}
+	if !websocket {
+		for _, s := range vm.Serials {
+			if s.Protocol == "telnet" && s.Port > 0 {
+				u, err := url.Parse(c.QtsURL)
+				if err != nil {
+					return nil, err
+				}
+				conn, err := net.Dial("tcp", net.JoinHostPort(u.Hostname(), fmt.Sprintf("%d", s.Port)))
+				if err != nil {
+					return nil, err
+				}
+				return &telnetConn{Conn: conn, br: bufio.NewReader(conn)}, nil
+			}
+		}
+	}
+	return c.dialWebsocket(fmt.Sprintf(QVSVMSerialWebsocket, fmt.Sprintf("%d", vm.ID)), "binary")
+}
+
+// telnetConn strips telnet commands from the data read and escapes IAC bytes written.
+type telnetConn struct {
+	net.Conn
+	br *bufio.Reader
+}
+
+func (t *telnetConn) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if n > 0 && t.br.Buffered() == 0 {
+			break
+		}
+		b, err := t.br.ReadByte()
+		if err != nil {
+			return n, err
+		}
+		if b != telnetIAC {
+			p[n] = b
+			n++
+			continue
+		}
+		cmd, err := t.br.ReadByte()
+		if err != nil {
+			return n, err
+		}
+		switch {
+		case cmd == telnetIAC:
+			p[n] = telnetIAC
+			n++
+		case cmd >= telnetWILL && cmd <= telnetDONT:
+			// Option negotiation, the defaults are fine for a serial line.
+			if _, err := t.br.ReadByte(); err != nil {
+				return n, err
+			}
+		case cmd == telnetSB:
+			for {
+				b, err := t.br.ReadByte()
+				if err != nil {
+					return n, err
+				}
+				if b == telnetIAC {
+					if b, err = t.br.ReadByte(); err != nil {
+						return n, err
+					}
+					if b == telnetSE {
+						break
+					}
+				}
+			}
+		}
+	}
+	return n, nil
+}
+
+func (t *telnetConn) Write(p []byte) (int, error) {
+	buf := make([]byte, 0, len(p))
+	for _, b := range p {
+		if b == telnetIAC {
+			buf = append(buf, telnetIAC)
+		}
+		buf = append(buf, b)
+	}
+	if _, err := t.Conn.Write(buf); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// attachSerial connects the terminal to the serial console until it closes or Ctrl-] is pressed.
+// Console output is also written to logw if it is not nil.
+func attachSerial(conn io.ReadWriteCloser, logw io.Writer) error {
+	fd := int(os.Stdin.Fd())
+	if terminal.IsTerminal(fd) {
+		state, err := terminal.MakeRaw(fd)
+		if err != nil {
+			return err
+		}
+		defer terminal.Restore(fd, state)
+	}
+
+	errc := make(chan error, 2)
+	go func() {
+		var out io.Writer = os.Stdout
+		if logw != nil {
+			out = io.MultiWriter(os.Stdout, logw)
+		}
+		_, err := io.Copy(out, conn)
+		errc <- err
+	}()
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, err := os.Stdin.Read(buf)
+			for i := 0; i < n; i++ {
+				if buf[i] == serialEscapeChar {
+					if i > 0 {
+						conn.Write(buf[:i])
+					}
+					errc <- nil
+					return
+				}
+			}
+			if n > 0 {
+				if _, err := conn.Write(buf[:n]); err != nil {
+					errc <- err
+					return
+				}
+			}
+			if err == io.EOF {
+				// Keep printing console output when stdin is not interactive.
+				return
+			}
+			if err != nil {
+				errc <- err
+				return
+			}
+		}
+	}()
+	err := <-errc
+	conn.Close()
+	if err == io.EOF {
+		return nil
+	}
+	return err
+}
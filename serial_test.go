@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+)
+
+func TestTelnetConnRead(t *testing.T) {
+	tests := []struct {
+		name string
+		in   []byte
+		want []byte
+	}{
+		{"plain", []byte("login: "), []byte("login: ")},
+		{"option negotiation", []byte{telnetIAC, telnetWILL, 1, 'o', 'k', telnetIAC, telnetDONT, 3}, []byte("ok")},
+		{"escaped IAC", []byte{'a', telnetIAC, telnetIAC, 'b'}, []byte{'a', telnetIAC, 'b'}},
+		{"subnegotiation", []byte{'x', telnetIAC, telnetSB, 24, 1, telnetIAC, telnetSE, 'y'}, []byte("xy")},
+		{"IAC in subnegotiation", []byte{telnetIAC, telnetSB, 24, telnetIAC, telnetIAC, 0, telnetIAC, telnetSE, 'z'}, []byte("z")},
+	}
+	for _, tt := range tests {
+		conn := &telnetConn{br: bufio.NewReader(bytes.NewReader(tt.in))}
+		got, err := ioutil.ReadAll(conn)
+		if err != nil && err != io.EOF {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !bytes.Equal(got, tt.want) {
+			t.Errorf("%s: read %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestTelnetConnWrite(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	conn := &telnetConn{Conn: client}
+	in := []byte{'a', telnetIAC, 'b'}
+	go func() {
+		n, err := conn.Write(in)
+		if err != nil || n != len(in) {
+			t.Errorf("Write = %d, %v, want %d", n, err, len(in))
+		}
+		client.Close()
+	}()
+	got, _ := ioutil.ReadAll(server)
+	if want := []byte{'a', telnetIAC, telnetIAC, 'b'}; !bytes.Equal(got, want) {
+		t.Errorf("wrote %v, want %v", got, want)
+	}
+}
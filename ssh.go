@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const defaultSSHUser = "ubuntu"
+
+// cloud-init writes boot-finished when all modules ran, guests without cloud-init are ready once sshd answers.
+const sshReadyCheck = "test -f /var/lib/cloud/instance/boot-finished || ! test -d /var/lib/cloud"
+
+// SSHTarget is a VM address and the credentials to log into it.
+type SSHTarget struct {
+	User     string
+	Host     string
+	Identity string
+
+	tempIdentity bool
+}
+
+// Close removes the identity file if it was written from the secrets store.
+func (t SSHTarget) Close() {
+	if t.tempIdentity {
+		os.Remove(t.Identity)
+	}
+}
+
+// generateSSHKeypair creates an ed25519 keypair with ssh-keygen and returns the private and public key.
+func generateSSHKeypair(comment string) (string, string, error) {
+	dir, err := ioutil.TempDir("", "qvs-ssh-key")
+	if err != nil {
+		return "", "", err
+	}
+	defer os.RemoveAll(dir)
+	keyFile := filepath.Join(dir, "id_ed25519")
+	cmd := exec.Command("ssh-keygen", "-q", "-t", "ed25519", "-N", "", "-C", comment, "-f", keyFile)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", "", fmt.Errorf("%s, %s. Is 'ssh-keygen' installed?", stderr.String(), err)
+	}
+	priv, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return "", "", err
+	}
+	pub, err := ioutil.ReadFile(keyFile + ".pub")
+	if err != nil {
+		return "", "", err
+	}
+	return string(priv), string(pub), nil
+}
+
+// sshIdentity returns the private key used to log into a VM: the key generated for the VM if there is one in
+// the secrets store, written to a temporary file, otherwise the private half of the authorized key file.
+func sshIdentity(vm VMResponse, secrets *SecretsStore, authorizedKeyFile string) (string, bool, error) {
+	if key := secrets.Get(vm.UUID, secretSSHPrivateKey); key != "" {
+		f, err := ioutil.TempFile("", "qvs-ssh-identity")
+		if err != nil {
+			return "", false, err
+		}
+		defer f.Close()
+		if _, err := f.WriteString(key); err != nil {
+			os.Remove(f.Name())
+			return "", false, err
+		}
+		return f.Name(), true, nil
+	}
+	keyFile := strings.TrimSuffix(authorizedKeyFile, ".pub")
+	if _, err := os.Stat(keyFile); err != nil {
+		return "", false, fmt.Errorf("no private key found for %s and no key was generated for VM %s", authorizedKeyFile, vm.Name)
+	}
+	return keyFile, false, nil
+}
+
+// VMSSHTarget resolves the address of a running VM. With wait, it blocks until the VM has an IP,
+// sshd answers and cloud-init has finished. The target must be closed after use.
+func (c *QVSClient) VMSSHTarget(idOrName, user string, secrets *SecretsStore, authorizedKeyFile string, wait bool, timeout time.Duration) (SSHTarget, error) {
+	vm, err := c.VMGet(idOrName)
+	if err != nil {
+		return SSHTarget{}, err
+	}
+	if vm.PowerState != QVSPowerStateRunning {
+		return SSHTarget{}, fmt.Errorf("error, VM %s is not running, current state: %s", vm.Name, vm.PowerState)
+	}
+	deadline := time.Now().Add(timeout)
+	var ips []string
+	if wait {
+		if ips, err = c.VMWaitIP(vm.Name, timeout); err != nil {
+			return SSHTarget{}, err
+		}
+	} else {
+		neighbors, _ := c.NetMgrNeighbors()
+		if ips = c.VMIPs(vm, neighbors); len(ips) == 0 {
+			return SSHTarget{}, fmt.Errorf("no IP address found for VM %s, use --wait if it is still booting", vm.Name)
+		}
+	}
+	identity, temp, err := sshIdentity(vm, secrets, authorizedKeyFile)
+	if err != nil {
+		return SSHTarget{}, err
+	}
+	t := SSHTarget{User: user, Host: ips[0], Identity: identity, tempIdentity: temp}
+	if wait {
+		if err := waitSSHReady(t, deadline.Sub(time.Now())); err != nil {
+			t.Close()
+			return SSHTarget{}, err
+		}
+	}
+	return t, nil
+}
+
+// waitSSHReady polls until port 22 accepts connections and cloud-init has finished.
+func waitSSHReady(t SSHTarget, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	addr := net.JoinHostPort(t.Host, "22")
+	log.Printf("INFO: Waiting for SSH on %s", addr)
+	for {
+		conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+		if err == nil {
+			conn.Close()
+			if err = t.Command(sshReadyCheck).Run(); err == nil {
+				return nil
+			}
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out after %s waiting for SSH and cloud-init on %s: %v", timeout, addr, err)
+		}
+		time.Sleep(5 * time.Second)
+	}
+}
+
+func (t SSHTarget) options() []string {
+	// VM addresses are reused between VMs, do not pin host keys.
+	return []string{
+		"-i", t.Identity,
+		"-o", "IdentitiesOnly=yes",
+		"-o", "StrictHostKeyChecking=no",
+		"-o", "UserKnownHostsFile=/dev/null",
+		"-o", "LogLevel=ERROR",
+		"-o", "ConnectTimeout=10",
+	}
+}
+
+// Command returns an ssh command running args on the VM, or an interactive login without args.
+func (t SSHTarget) Command(args ...string) *exec.Cmd {
+	sshArgs := append(t.options(), fmt.Sprintf("%s@%s", t.User, t.Host))
+	if len(args) > 0 {
+		sshArgs = append(sshArgs, "--")
+		sshArgs = append(sshArgs, args...)
+	}
+	return exec.Command("ssh", sshArgs...)
+}
+
+// SCPCommand returns an scp command, arguments of the form 'vm:path' are rewritten to the VM address.
+func (t SSHTarget) SCPCommand(vmName string, recursive bool, args ...string) *exec.Cmd {
+	scpArgs := t.options()
+	if recursive {
+		scpArgs = append(scpArgs, "-r")
+	}
+	for _, a := range args {
+		if strings.HasPrefix(a, vmName+":") {
+			a = fmt.Sprintf("%s@%s:%s", t.User, scpHost(t.Host), strings.TrimPrefix(a, vmName+":"))
+		}
+		scpArgs = append(scpArgs, a)
+	}
+	return exec.Command("scp", scpArgs...)
+}
+
+// scp needs IPv6 addresses in brackets.
+func scpHost(host string) string {
+	if strings.Contains(host, ":") {
+		return "[" + host + "]"
+	}
+	return host
+}
+
+// scpVMName returns the VM referenced by 'vm:path' arguments, exactly one VM must be referenced.
+func scpVMName(args []string) (string, error) {
+	name := ""
+	for _, a := range args {
+		i := strings.Index(a, ":")
+		if i <= 0 || strings.Contains(a[:i], "/") {
+			continue
+		}
+		if name != "" && name != a[:i] {
+			return "", fmt.Errorf("only one VM can be referenced in scp arguments, got %s and %s", name, a[:i])
+		}
+		name = a[:i]
+	}
+	if name == "" {
+		return "", fmt.Errorf("no remote path given, use <vm>:<path> for the source or destination")
+	}
+	return name, nil
+}
+
+func runAttached(cmd *exec.Cmd) error {
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
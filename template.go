@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/Masterminds/sprig"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// SeedTemplateData is the data of user supplied user-data and meta-data templates.
+type SeedTemplateData struct {
+	Name      string
+	MAC       string
+	IP        string
+	NICs      []NIC
+	TS        int64
+	Timestamp string
+	Image     string
+	Values    map[string]interface{}
+}
+
+func newSeedTemplateData(cfg SeedConfig) SeedTemplateData {
+	data := SeedTemplateData{
+		Name:      cfg.Name,
+		NICs:      cfg.NICs,
+		TS:        cfg.TS,
+		Timestamp: time.Unix(cfg.TS, 0).UTC().Format(time.RFC3339),
+		Image:     cfg.Image,
+		Values:    cfg.Values,
+	}
+	if data.Values == nil {
+		data.Values = make(map[string]interface{})
+	}
+	if len(cfg.NICs) > 0 {
+		data.MAC = cfg.NICs[0].MAC
+		data.IP = strings.SplitN(cfg.NICs[0].IP, "/", 2)[0]
+	}
+	return data
+}
+
+// renderTemplateFile renders the template in src to dst with the sprig functions and include,
+// which executes one of the partials, named by their file name, or a template they define.
+// Referencing a missing key of .Values is an error.
+func renderTemplateFile(src, dst string, partials []string, data interface{}) error {
+	content, err := ioutil.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	t := template.New(filepath.Base(src)).Option("missingkey=error").Funcs(sprig.TxtFuncMap())
+	t.Funcs(template.FuncMap{
+		"include": func(name string, data interface{}) (string, error) {
+			var b bytes.Buffer
+			err := t.ExecuteTemplate(&b, name, data)
+			return b.String(), err
+		},
+	})
+	for _, p := range partials {
+		pc, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		if _, err := t.New(filepath.Base(p)).Parse(string(pc)); err != nil {
+			return fmt.Errorf("error parsing template %s: %v", p, err)
+		}
+	}
+	if _, err := t.Parse(string(content)); err != nil {
+		return fmt.Errorf("error parsing template %s: %v", src, err)
+	}
+
+	var b bytes.Buffer
+	if err := t.Execute(&b, data); err != nil {
+		return fmt.Errorf("error rendering template %s: %v", src, err)
+	}
+	return ioutil.WriteFile(dst, b.Bytes(), 0644)
+}
+
+// loadTemplateValues reads a YAML values file and applies --set key=value overrides on top.
+// Dotted keys set nested values: --set app.port=8080. Values given with --set are always strings.
+func loadTemplateValues(file string, sets []string) (map[string]interface{}, error) {
+	values := make(map[string]interface{})
+	if file != "" {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		var doc map[interface{}]interface{}
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return nil, fmt.Errorf("error reading values file %s: %v", file, err)
+		}
+		values = stringKeys(doc)
+	}
+	for _, s := range sets {
+		toks := strings.SplitN(s, "=", 2)
+		if len(toks) != 2 || toks[0] == "" {
+			return nil, fmt.Errorf("invalid --set '%s', expected key=value", s)
+		}
+		keys := strings.Split(toks[0], ".")
+		m := values
+		for _, k := range keys[:len(keys)-1] {
+			next, ok := m[k].(map[string]interface{})
+			if !ok {
+				next = make(map[string]interface{})
+				m[k] = next
+			}
+			m = next
+		}
+		m[keys[len(keys)-1]] = toks[1]
+	}
+	return values, nil
+}
+
+// stringKeys converts the maps decoded by yaml to maps with string keys, so templates can index them.
+func stringKeys(m map[interface{}]interface{}) map[string]interface{} {
+	res := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		res[fmt.Sprint(k)] = stringKeysValue(v)
+	}
+	return res
+}
+
+func stringKeysValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		return stringKeys(v)
+	case []interface{}:
+		res := make([]interface{}, len(v))
+		for i, e := range v {
+			res[i] = stringKeysValue(e)
+		}
+		return res
+	}
+	return v
+}
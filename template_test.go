@@ -0,0 +1,134 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLoadTemplateValues(t *testing.T) {
+	dir, err := ioutil.TempDir("", "qvscli-values")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	valuesFile := filepath.Join(dir, "values.yaml")
+	values := "app:\n  port: 8080\n  hosts:\n    - name: a\n    - name: b\nver: \"1.10\"\n"
+	if err := ioutil.WriteFile(valuesFile, []byte(values), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		file    string
+		sets    []string
+		want    map[string]interface{}
+		wantErr bool
+	}{
+		{"empty", "", nil, map[string]interface{}{}, false},
+		{"strings", "", []string{"ver=1.10", "zip=01234", "on=true", "empty="},
+			map[string]interface{}{"ver": "1.10", "zip": "01234", "on": "true", "empty": ""}, false},
+		{"value with equals", "", []string{"args=a=b"}, map[string]interface{}{"args": "a=b"}, false},
+		{"nested", "", []string{"app.port=8080", "app.name=web"},
+			map[string]interface{}{"app": map[string]interface{}{"port": "8080", "name": "web"}}, false},
+		{"values file", valuesFile, nil, map[string]interface{}{
+			"app": map[string]interface{}{
+				"port":  8080,
+				"hosts": []interface{}{map[string]interface{}{"name": "a"}, map[string]interface{}{"name": "b"}},
+			},
+			"ver": "1.10",
+		}, false},
+		{"set overrides values file", valuesFile, []string{"app.port=9090", "ver=2"}, map[string]interface{}{
+			"app": map[string]interface{}{
+				"port":  "9090",
+				"hosts": []interface{}{map[string]interface{}{"name": "a"}, map[string]interface{}{"name": "b"}},
+			},
+			"ver": "2",
+		}, false},
+		{"set replaces a scalar with a map", "", []string{"ver=1", "ver.major=1"},
+			map[string]interface{}{"ver": map[string]interface{}{"major": "1"}}, false},
+		{"missing equals", "", []string{"ver"}, nil, true},
+		{"missing key", "", []string{"=1"}, nil, true},
+		{"missing values file", filepath.Join(dir, "missing.yaml"), nil, nil, true},
+	}
+	for _, tt := range tests {
+		got, err := loadTemplateValues(tt.file, tt.sets)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if tt.wantErr {
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %#v, want %#v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestLoadTemplateValuesInvalidFile(t *testing.T) {
+	f, err := ioutil.TempFile("", "qvscli-values")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("app: [1, 2\n")
+	f.Close()
+	if _, err := loadTemplateValues(f.Name(), nil); err == nil {
+		t.Error("expected an error for an invalid values file")
+	}
+}
+
+func TestRenderTemplateFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "qvscli-template")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	partial := filepath.Join(dir, "users.tpl")
+	if err := ioutil.WriteFile(partial, []byte(`{{ define "greeting" }}hello {{ . }}{{ end }}users: {{ .Values.user }}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	data := SeedTemplateData{Name: "web", Values: map[string]interface{}{"user": "ubuntu", "app": map[string]interface{}{"port": "8080"}}}
+
+	tests := []struct {
+		name     string
+		template string
+		partials []string
+		want     string
+		wantErr  bool
+	}{
+		{"fields", "{{ .Name }}:{{ .Values.app.port }}", nil, "web:8080", false},
+		{"sprig", `{{ .Name | upper }}`, nil, "WEB", false},
+		{"include partial", `{{ include "users.tpl" . }}`, []string{partial}, "users: ubuntu", false},
+		{"include define", `{{ include "greeting" .Name | upper }}`, []string{partial}, "HELLO WEB", false},
+		{"missing value", "{{ .Values.missing }}", nil, "", true},
+		{"missing nested value", "{{ .Values.app.host }}", nil, "", true},
+		{"missing partial", `{{ include "other.tpl" . }}`, []string{partial}, "", true},
+		{"parse error", "{{ .Name ", nil, "", true},
+	}
+	for _, tt := range tests {
+		src := filepath.Join(dir, "user-data")
+		dst := filepath.Join(dir, "user-data.out")
+		if err := ioutil.WriteFile(src, []byte(tt.template), 0644); err != nil {
+			t.Fatal(err)
+		}
+		err := renderTemplateFile(src, dst, tt.partials, data)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if tt.wantErr {
+			continue
+		}
+		got, err := ioutil.ReadFile(dst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -5,6 +5,8 @@ import "net/http/cookiejar"
 const QTSAuthLogin = "/cgi-bin/authLogin.cgi"
 const QTSFileStation = "/cgi-bin/filemanager/utilRequest.cgi"
 const QTSNetManager = "/netmgr/api.fcgi/api/net"
+const QTSNetManagerDHCP = "/netmgr/api.fcgi/api/dhcp"
+const QTSNetManagerARP = "/netmgr/api.fcgi/api/arp"
 
 const QVSRoot = "/qvs"
 const QVSGetMAC = "/qvs/vms/mac"
@@ -13,10 +15,27 @@ const QVSVMStart = "/qvs/vms/%s/start"
 const QVSVMReset = "/qvs/vms/%s/reset"
 const QVSVMForceShutdown = "/qvs/vms/%s/forceshutdown"
 const QVSVMShutdown = "/qvs/vms/%s/shutdown"
+const QVSVMReboot = "/qvs/vms/%s/reboot"
+const QVSVMSuspend = "/qvs/vms/%s/suspend"
+const QVSVMResume = "/qvs/vms/%s/resume"
+const QVSVMDisks = "/qvs/vms/%s/disks"
+const QVSVMDisk = "/qvs/vms/%s/disks/%d"
+const QVSVMAdapters = "/qvs/vms/%s/adapters"
+const QVSVMAdapter = "/qvs/vms/%s/adapters/%d"
+const QVSVMCDROMs = "/qvs/vms/%s/cdroms"
+const QVSVMCDROM = "/qvs/vms/%s/cdroms/%d"
 const QVSVMSnapshots = "/qvs/vms/%s/snapshots"
 const QVSVMSnapshot = "/qvs/vms/%s/snapshots/%s"
+const QVSVMAgentInterfaces = "/qvs/vms/%s/agent/interfaces"
+const QVSVMGraphics = "/qvs/vms/%s/graphics/%d"
+const QVSVMConsoleWebsocket = "/qvs/vms/%s/console/websocket"
+const QVSVMSerialWebsocket = "/qvs/vms/%s/serial/websocket"
 const QVSVNCTpl = "/qvs/#/console/vms/%s"
 
+const QVSPowerStateStop = "stop"
+const QVSPowerStateRunning = "running"
+const QVSPowerStatePaused = "paused"
+
 const QVSStatusOK = 0
 const QVSStatusDeferred = 8
 
@@ -67,14 +86,28 @@ type ListVMsResponse struct {
 }
 
 type VMResponse struct {
-	ID         int                  `json:"id"`
-	UUID       string               `json:"uuid"`
-	Name       string               `json:"name"`
-	Cores      int                  `json:"cores"`
-	PowerState string               `json:"power_state"`
-	Disks      []VMDisksResponse    `json:"disks"`
-	Adapters   []VMAdaptersResponse `json:"adapters"`
-	Graphics   []VMGraphicsResponse `json:"graphics"`
+	ID          int                  `json:"id"`
+	UUID        string               `json:"uuid"`
+	Name        string               `json:"name"`
+	Description string               `json:"description"`
+	OSType      string               `json:"os_type"`
+	Cores       int                  `json:"cores"`
+	Memory      int64                `json:"memory"`
+	PowerState  string               `json:"power_state"`
+	Disks       []VMDisksResponse    `json:"disks"`
+	Adapters    []VMAdaptersResponse `json:"adapters"`
+	Graphics    []VMGraphicsResponse `json:"graphics"`
+	CDROMs      []VMCDROMsResponse   `json:"cdroms"`
+	Serials     []VMSerialsResponse  `json:"serials"`
+	Firmware    string               `json:"firmware"`
+	SecureBoot  bool                 `json:"secure_boot"`
+	Machine     string               `json:"machine"`
+	CPUMode     string               `json:"cpu_mode"`
+	CPUModel    string               `json:"cpu_model"`
+	Sockets     int                  `json:"sockets"`
+	Threads     int                  `json:"threads"`
+	AutoStart   bool                 `json:"auto_start"`
+	IPs         []string             `json:"ips,omitempty"` // filled in by qvscli, see VMIPs
 }
 
 type VMDisksResponse struct {
@@ -95,12 +128,29 @@ type VMDisksResponse struct {
 	VolumeName string `json:"volume_name"`
 }
 
+type VMCDROMsResponse struct {
+	ID        int    `json:"id"`
+	VMID      int    `json:"vm_id"`
+	Path      string `json:"path"`
+	BootOrder int    `json:"boot_order"`
+	Index     int    `json:"index"`
+}
+
+type VMSerialsResponse struct {
+	ID       int    `json:"id"`
+	VMID     int    `json:"vm_id"`
+	Type     string `json:"type"`
+	Port     int    `json:"port"`
+	Protocol string `json:"protocol"`
+}
+
 type VMAdaptersResponse struct {
 	ID     int    `json:"id"`
 	VMID   int    `json:"vm_id"`
 	MAC    string `json:"mac"`
 	Bridge string `json:"bridge"`
 	Model  string `json:"model"`
+	VLAN   int    `json:"vlan"`
 	Index  int    `json:"index"`
 }
 
@@ -128,6 +178,29 @@ type VMSnapshotResponse struct {
 	AppStates         []interface{} `json:"app_states"`
 }
 
+type VMAgentInterfacesResponse struct {
+	Status int                `json:"status"`
+	Data   []VMAgentInterface `json:"data"`
+}
+
+// VMAgentInterface is a guest network interface as reported by the QEMU guest agent.
+type VMAgentInterface struct {
+	Name        string `json:"name"`
+	MAC         string `json:"hardware_address"`
+	IPAddresses []struct {
+		IP     string `json:"ip_address"`
+		Type   string `json:"ip_address_type"`
+		Prefix int    `json:"prefix"`
+	} `json:"ip_addresses"`
+}
+
+// NetMgrNeighbor is a DHCP lease or ARP entry known to the NAS.
+type NetMgrNeighbor struct {
+	IP  string `json:"ip"`
+	MAC string `json:"mac"`
+	Dev string `json:"dev"`
+}
+
 type NetMgrNet struct {
 	DisplayName string `json:"display_name"`
 	PhysicalNIC string `json:"physical_nic"`
@@ -148,13 +221,26 @@ type QVSCreateRequest struct {
 	Description    string                     `json:"description"`
 	OSType         string                     `json:"os_type"`
 	Cores          int                        `json:"cores"`
-	Memory         int                        `json:"memory"`
+	Memory         int64                      `json:"memory"`
 	Adapters       []map[string]string        `json:"adapters"`
 	QVM            bool                       `json:"qvm"`
 	IsAgentEnabled bool                       `json:"is_agent_enabled"`
 	CDROMs         []map[string]string        `json:"cdroms"`
+	Serials        []map[string]string        `json:"serials,omitempty"`
 	Disks          []map[string]string        `json:"disks"`
 	Graphics       []QVSCreateGraphicsRequest `json:"graphics"`
+	QVSHardwareRequest
+}
+
+type QVSHardwareRequest struct {
+	Firmware   string `json:"firmware,omitempty"`
+	SecureBoot *bool  `json:"secure_boot,omitempty"`
+	Machine    string `json:"machine,omitempty"`
+	CPUMode    string `json:"cpu_mode,omitempty"`
+	CPUModel   string `json:"cpu_model,omitempty"`
+	Sockets    int    `json:"sockets,omitempty"`
+	Threads    int    `json:"threads,omitempty"`
+	AutoStart  *bool  `json:"auto_start,omitempty"`
 }
 
 type QVSSnapshotRequest struct {
@@ -168,5 +254,24 @@ type QVSCreateGraphicsRequest struct {
 	Password       string `json:"password"`
 }
 
+type ImageMetadata struct {
+	SourceVM   string `json:"source_vm"`
+	SourceUUID string `json:"source_uuid"`
+	OSType     string `json:"os_type"`
+	Cores      int    `json:"cores"`
+	Memory     int64  `json:"memory"`
+	DiskSize   uint64 `json:"disk_size"`
+	Format     string `json:"format"`
+	CreatedAt  string `json:"created_at"`
+}
+
 const DefaultMetaData = `instance-id: qvs-%s-%d
 local-hostname: %s`
+
+type QVSUpdateRequest struct {
+	Name        string  `json:"name,omitempty"`
+	Description *string `json:"description,omitempty"`
+	Cores       int     `json:"cores,omitempty"`
+	Memory      int64   `json:"memory,omitempty"`
+	QVSHardwareRequest
+}
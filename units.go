@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var sizeRegex = regexp.MustCompile(`^([0-9]+(?:\.[0-9]+)?)\s*([KMGT]?)(?:I?B)?$`)
+
+// parseSize parses human readable sizes like 512M, 40G or 1.5T into bytes, units are powers of 1024.
+// A number without a unit is taken as bytes.
+func parseSize(s string) (uint64, error) {
+	m := sizeRegex.FindStringSubmatch(strings.ToUpper(strings.TrimSpace(s)))
+	if m == nil {
+		return 0, fmt.Errorf("invalid size: %s", s)
+	}
+	n, err := strconv.ParseFloat(m[1], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size: %s", s)
+	}
+	mult := uint64(1)
+	switch m[2] {
+	case "K":
+		mult = 1 << 10
+	case "M":
+		mult = 1 << 20
+	case "G":
+		mult = 1 << 30
+	case "T":
+		mult = 1 << 40
+	}
+	f := n * float64(mult)
+	if f >= math.MaxUint64 {
+		return 0, fmt.Errorf("size too large: %s", s)
+	}
+	size := uint64(f)
+	if size == 0 {
+		return 0, fmt.Errorf("invalid size: %s", s)
+	}
+	return size, nil
+}
+
+func formatSize(b uint64) string {
+	units := []string{"", "K", "M", "G", "T"}
+	i := 0
+	f := float64(b)
+	for f >= 1024 && i < len(units)-1 {
+		f /= 1024
+		i++
+	}
+	if f == float64(uint64(f)) {
+		return fmt.Sprintf("%d%s", uint64(f), units[i])
+	}
+	return fmt.Sprintf("%.1f%s", f, units[i])
+}
@@ -0,0 +1,55 @@
+package main
+
+import "testing"
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    uint64
+		wantErr bool
+	}{
+		{"10G", 10 << 30, false},
+		{"512M", 512 << 20, false},
+		{"1.5T", 3 << 39, false},
+		{"64k", 64 << 10, false},
+		{"40GiB", 40 << 30, false},
+		{"2 GB", 2 << 30, false},
+		{"4096", 4096, false},
+		{"0", 0, true},
+		{"0G", 0, true},
+		{"", 0, true},
+		{"abc", 0, true},
+		{"10X", 0, true},
+		{"-1G", 0, true},
+		{"16777215T", 16777215 << 40, false},
+		{"16777216T", 0, true},
+		{"99999999999999999999", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseSize(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseSize(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseSize(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestFormatSize(t *testing.T) {
+	tests := []struct {
+		in   uint64
+		want string
+	}{
+		{512, "512"},
+		{10 << 30, "10G"},
+		{3 << 39, "1.5T"},
+		{1536 << 10, "1.5M"},
+	}
+	for _, tt := range tests {
+		if got := formatSize(tt.in); got != tt.want {
+			t.Errorf("formatSize(%d) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -4,11 +4,43 @@ const DefaultUserDataTemplate = `#cloud-config
 hostname: {{.Hostname}}
 
 {{- if .LocalLogin}}
-password: "{{.LoginPassword}}"
+user:
+  hashed_passwd: "{{.LoginPasswordHash}}"
+  lock_passwd: false
 ssh_pwauth: True
 chpasswd: { expire: False }
 {{- end}}
 
+{{- if .Users}}
+users:
+- default
+{{- range .Users}}
+- name: {{.Name}}
+  {{- if .Groups}}
+  groups: {{join "," .Groups}}
+  {{- end}}
+  {{- if .Sudo}}
+  sudo: {{quote .Sudo}}
+  {{- end}}
+  {{- if .Shell}}
+  shell: {{.Shell}}
+  {{- end}}
+  lock_passwd: true
+  {{- if .SSHAuthorizedKeys}}
+  ssh_authorized_keys:
+  {{- range .SSHAuthorizedKeys}}
+  - {{quote .}}
+  {{- end}}
+  {{- end}}
+  {{- if .SSHImportIDs}}
+  ssh_import_id:
+  {{- range .SSHImportIDs}}
+  - {{.}}
+  {{- end}}
+  {{- end}}
+{{- end}}
+{{- end}}
+
 write_files:
 - path: /etc/network/if-up.d/show-ip-address
   permissions: '0755'
@@ -24,8 +56,75 @@ write_files:
 {{.StartupScript | indent 4}}
 {{- end}}
 
+{{- if .GrowRoot}}
+growpart:
+  mode: auto
+  devices: ['/']
+resize_rootfs: true
+{{- end}}
+
+{{- if .DataDisks}}
+disk_setup:
+{{- range .DataDisks}}
+  {{.Dev}}:
+    table_type: gpt
+    layout: true
+    overwrite: false
+{{- end}}
+fs_setup:
+{{- range .DataDisks}}
+- label: {{.Label}}
+  filesystem: {{.FS}}
+  device: {{.Dev}}
+  partition: auto
+{{- end}}
+mounts:
+{{- range .DataDisks}}
+{{- if ne .MountPoint "none"}}
+- [ "LABEL={{.Label}}", "{{.MountPoint}}", "{{.FS}}", "defaults,nofail", "0", "2" ]
+{{- end}}
+{{- end}}
+{{- end}}
+
 ssh_authorized_keys:
-- {{.AuthorizedKey}}
+{{- range .AuthorizedKeys}}
+- {{quote .}}
+{{- end}}
+{{- if .SSHImportIDs}}
+ssh_import_id:
+{{- range .SSHImportIDs}}
+- {{.}}
+{{- end}}
+{{- end}}
 power_state:
   mode: reboot
 `
+
+// DefaultNetworkConfigTemplate is a netplan v2 network-config, interfaces are matched by MAC address.
+const DefaultNetworkConfigTemplate = `version: 2
+ethernets:
+{{- range $i, $nic := .NICs}}
+  nic{{$i}}:
+    match:
+      macaddress: "{{lower $nic.MAC}}"
+{{- if $nic.Static}}
+    addresses: [ "{{$nic.IP}}" ]
+{{- if $nic.Gateway}}
+    routes:
+    - to: {{$nic.DefaultRoute}}
+      via: {{$nic.Gateway}}
+{{- end}}
+{{- else}}
+    dhcp4: true
+{{- end}}
+{{- if or $nic.DNS $nic.Search}}
+    nameservers:
+{{- if $nic.DNS}}
+      addresses: [ {{join ", " $nic.DNS}} ]
+{{- end}}
+{{- if $nic.Search}}
+      search: [ {{join ", " $nic.Search}} ]
+{{- end}}
+{{- end}}
+{{- end}}
+`
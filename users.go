@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// CloudUser is an additional user created by cloud-init next to the default user of the image.
+type CloudUser struct {
+	Name              string   `json:"name"`
+	Groups            []string `json:"groups,omitempty"`
+	Sudo              string   `json:"sudo,omitempty"`
+	Shell             string   `json:"shell,omitempty"`
+	SSHAuthorizedKeys []string `json:"ssh_authorized_keys,omitempty"`
+	SSHImportIDs      []string `json:"ssh_import_id,omitempty"`
+}
+
+var userNameRegex = regexp.MustCompile(`^[a-z_][a-z0-9_-]{0,31}$`)
+
+// ssh-import-id sources: GitHub and Launchpad.
+var sshImportIDRegex = regexp.MustCompile(`^(gh|lp):[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// parseCloudUser parses an --add-user spec, list values are separated by ';':
+// name=alice,groups=sudo;docker,sudo=ALL=(ALL) NOPASSWD:ALL,shell=/bin/bash,keys=~/.ssh/a.pub;~/.ssh/b.pub,ssh-import-id=gh:alice
+// Commas separate the options, so the commands of a sudo rule are separated by ';' as well and joined with ','.
+func parseCloudUser(spec string) (CloudUser, error) {
+	kv, err := parseKeyValues(spec, []string{"name", "groups", "sudo", "shell", "keys", "ssh-import-id"})
+	if err != nil {
+		return CloudUser{}, err
+	}
+	u := CloudUser{
+		Name:         kv["name"],
+		Groups:       splitList(kv["groups"]),
+		Sudo:         strings.Join(splitList(kv["sudo"]), ","),
+		Shell:        kv["shell"],
+		SSHImportIDs: splitList(kv["ssh-import-id"]),
+	}
+	for _, f := range splitList(kv["keys"]) {
+		keys, err := readAuthorizedKeys(f)
+		if err != nil {
+			return u, err
+		}
+		u.SSHAuthorizedKeys = append(u.SSHAuthorizedKeys, keys...)
+	}
+	return u, u.validate()
+}
+
+func (u CloudUser) validate() error {
+	if !userNameRegex.MatchString(u.Name) {
+		return fmt.Errorf("invalid user name '%s'", u.Name)
+	}
+	if u.Shell != "" && !strings.HasPrefix(u.Shell, "/") {
+		return fmt.Errorf("invalid shell '%s' for user %s, must be an absolute path", u.Shell, u.Name)
+	}
+	if len(u.SSHAuthorizedKeys) == 0 && len(u.SSHImportIDs) == 0 {
+		return fmt.Errorf("user %s has no SSH keys, give keys or ssh-import-id", u.Name)
+	}
+	return validateSSHImportIDs(u.SSHImportIDs)
+}
+
+// loadCloudUsers reads a JSON list of users, with the fields of CloudUser.
+func loadCloudUsers(file string) ([]CloudUser, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var users []CloudUser
+	if err := json.Unmarshal(data, &users); err != nil {
+		return nil, fmt.Errorf("error reading users file %s: %v", file, err)
+	}
+	for _, u := range users {
+		if err := u.validate(); err != nil {
+			return nil, fmt.Errorf("%s: %v", file, err)
+		}
+	}
+	return users, nil
+}
+
+// cloudUsers combines the users of a users file and of --add-user specs, names must be unique.
+func cloudUsers(file string, specs []string) ([]CloudUser, error) {
+	var users []CloudUser
+	if file != "" {
+		var err error
+		if users, err = loadCloudUsers(file); err != nil {
+			return nil, err
+		}
+	}
+	for _, spec := range specs {
+		u, err := parseCloudUser(spec)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	seen := make(map[string]bool)
+	for _, u := range users {
+		if seen[u.Name] {
+			return nil, fmt.Errorf("user %s is defined more than once", u.Name)
+		}
+		seen[u.Name] = true
+	}
+	return users, nil
+}
+
+func validateSSHImportIDs(ids []string) error {
+	for _, id := range ids {
+		if !sshImportIDRegex.MatchString(id) {
+			return fmt.Errorf("invalid ssh-import-id '%s', expected gh:<user> or lp:<user>", id)
+		}
+	}
+	return nil
+}
+
+// readAuthorizedKeys returns the keys in an authorized keys file, one per line.
+func readAuthorizedKeys(file string) ([]string, error) {
+	if strings.HasPrefix(file, "~/") {
+		file = filepath.Join(os.Getenv("HOME"), file[2:])
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			keys = append(keys, line)
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no SSH keys found in %s", file)
+	}
+	return keys, nil
+}
+
+func splitList(s string) []string {
+	var list []string
+	for _, v := range strings.Split(s, ";") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseCloudUser(t *testing.T) {
+	dir, err := ioutil.TempDir("", "qvs-users-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	keyFile := filepath.Join(dir, "a.pub")
+	if err := ioutil.WriteFile(keyFile, []byte("# alice\nssh-ed25519 AAAA alice@a\n\nssh-rsa BBBB alice@b\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		spec    string
+		want    CloudUser
+		wantErr bool
+	}{
+		{"name=alice,ssh-import-id=gh:alice", CloudUser{Name: "alice", SSHImportIDs: []string{"gh:alice"}}, false},
+		{"name=bob,groups=sudo;docker,sudo=ALL=(ALL) NOPASSWD:ALL,shell=/bin/bash,keys=" + keyFile,
+			CloudUser{Name: "bob", Groups: []string{"sudo", "docker"}, Sudo: "ALL=(ALL) NOPASSWD:ALL", Shell: "/bin/bash",
+				SSHAuthorizedKeys: []string{"ssh-ed25519 AAAA alice@a", "ssh-rsa BBBB alice@b"}}, false},
+		{"name=ops,sudo=ALL=(ALL) NOPASSWD:/usr/bin/apt;/usr/bin/systemctl,ssh-import-id=lp:ops;gh:ops",
+			CloudUser{Name: "ops", Sudo: "ALL=(ALL) NOPASSWD:/usr/bin/apt,/usr/bin/systemctl", SSHImportIDs: []string{"lp:ops", "gh:ops"}}, false},
+		{"name=alice", CloudUser{}, true},
+		{"name=Alice,ssh-import-id=gh:alice", CloudUser{}, true},
+		{"name=alice,shell=bash,ssh-import-id=gh:alice", CloudUser{}, true},
+		{"name=alice,ssh-import-id=github:alice", CloudUser{}, true},
+		{"name=alice,keys=" + filepath.Join(dir, "missing.pub"), CloudUser{}, true},
+		{"name=alice,sudo=ALL=(ALL) NOPASSWD:/usr/bin/apt,/usr/bin/systemctl,ssh-import-id=gh:alice", CloudUser{}, true},
+	}
+	for _, tt := range tests {
+		got, err := parseCloudUser(tt.spec)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseCloudUser(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseCloudUser(%q) = %+v, want %+v", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestCloudUsersUnique(t *testing.T) {
+	if _, err := cloudUsers("", []string{"name=alice,ssh-import-id=gh:alice", "name=alice,ssh-import-id=gh:bob"}); err == nil {
+		t.Error("cloudUsers with a duplicate name: expected an error")
+	}
+}
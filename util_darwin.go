@@ -5,12 +5,14 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
 )
 
-func makeConfigISO(metadataISOFile, metaDataFile, userDataFile string) error {
+// makeConfigISO packs a NoCloud seed ISO, networkConfigFile is optional.
+func makeConfigISO(metadataISOFile, metaDataFile, userDataFile, networkConfigFile string) error {
 	dir, err := ioutil.TempDir("", "ci-tmp-data")
 	defer os.RemoveAll(dir) // clean up
 	if err != nil {
@@ -24,7 +26,21 @@ func makeConfigISO(metadataISOFile, metaDataFile, userDataFile string) error {
 	if err := copyFile(userDataFile, tmpUserData); err != nil {
 		return err
 	}
-	cmd := exec.Command("mkisofs", "-output", metadataISOFile, "-volid", "cidata", "-joliet", "-rock", tmpUserData, tmpMetaData)
+	files := []string{tmpUserData, tmpMetaData}
+	if networkConfigFile != "" {
+		tmpNetworkConfig := filepath.Join(dir, "network-config")
+		if err := copyFile(networkConfigFile, tmpNetworkConfig); err != nil {
+			return err
+		}
+		files = append(files, tmpNetworkConfig)
+	}
+	return makeISO(metadataISOFile, "cidata", files...)
+}
+
+// makeISO packs files into the root of an ISO 9660 image with the given volume label.
+func makeISO(isoFile, volID string, files ...string) error {
+	args := append([]string{"-output", isoFile, "-volid", volID, "-joliet", "-rock"}, files...)
+	cmd := exec.Command("mkisofs", args...)
 	var stderr bytes.Buffer
 	cmd.Stderr = &stderr
 	if err := cmd.Run(); err != nil {
@@ -52,3 +68,12 @@ func copyFile(src, dst string) error {
 	}
 	return out.Close()
 }
+
+// openVNCViewer starts a VNC viewer for addr (host:port) in the background, Screen Sharing by default.
+func openVNCViewer(viewer, addr string) error {
+	if viewer == "" {
+		return exec.Command("open", "vnc://"+addr).Start()
+	}
+	host, port, _ := net.SplitHostPort(addr)
+	return exec.Command(viewer, fmt.Sprintf("%s::%s", host, port)).Start()
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+)
+
+// Minimal RFC 6455 websocket client, enough to carry the byte streams of the QVS console.
+
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC11B65"
+
+const (
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpBinary       = 0x2
+	wsOpClose        = 0x8
+	wsOpPing         = 0x9
+	wsOpPong         = 0xa
+)
+
+// wsConn exchanges binary websocket messages as a byte stream.
+type wsConn struct {
+	net.Conn
+	br      *bufio.Reader
+	wmu     sync.Mutex
+	payload []byte
+}
+
+// dialWebsocket opens a websocket to path on the QTS server, authenticated with the session cookies.
+func (c *QVSClient) dialWebsocket(path string, protocol string) (net.Conn, error) {
+	u, err := url.Parse(c.QtsURL + path)
+	if err != nil {
+		return nil, err
+	}
+	host := u.Host
+	var conn net.Conn
+	if u.Scheme == "https" {
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "443")
+		}
+		conn, err = tls.Dial("tcp", host, &tls.Config{ServerName: u.Hostname()})
+	} else {
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "80")
+		}
+		conn, err = net.Dial("tcp", host)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+	key := base64.StdEncoding.EncodeToString(nonce)
+
+	req, _ := http.NewRequest("GET", u.String(), nil)
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	if protocol != "" {
+		req.Header.Set("Sec-WebSocket-Protocol", protocol)
+	}
+	req.Header.Set("Origin", c.QtsURL)
+	req.Header.Set("X-CSRFToken", c.QVSCSRFToken)
+	for _, cookie := range c.CookieJar.Cookies(u) {
+		req.AddCookie(cookie)
+	}
+	c.reqDebug(req)
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	c.respDebug(resp)
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		conn.Close()
+		return nil, fmt.Errorf("error opening websocket %s, HTTP status code: %d", path, resp.StatusCode)
+	}
+	h := sha1.Sum([]byte(key + websocketGUID))
+	if resp.Header.Get("Sec-WebSocket-Accept") != base64.StdEncoding.EncodeToString(h[:]) {
+		conn.Close()
+		return nil, fmt.Errorf("error opening websocket %s, invalid Sec-WebSocket-Accept header", path)
+	}
+
+	return &wsConn{Conn: conn, br: br}, nil
+}
+
+func (ws *wsConn) Read(p []byte) (int, error) {
+	for len(ws.payload) == 0 {
+		op, payload, err := ws.readFrame()
+		if err != nil {
+			return 0, err
+		}
+		switch op {
+		case wsOpText, wsOpBinary, wsOpContinuation:
+			ws.payload = payload
+		case wsOpPing:
+			if err := ws.writeFrame(wsOpPong, payload); err != nil {
+				return 0, err
+			}
+		case wsOpClose:
+			ws.writeFrame(wsOpClose, nil)
+			return 0, io.EOF
+		}
+	}
+	n := copy(p, ws.payload)
+	ws.payload = ws.payload[n:]
+	return n, nil
+}
+
+func (ws *wsConn) Write(p []byte) (int, error) {
+	if err := ws.writeFrame(wsOpBinary, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (ws *wsConn) readFrame() (byte, []byte, error) {
+	hdr := make([]byte, 2)
+	if _, err := io.ReadFull(ws.br, hdr); err != nil {
+		return 0, nil, err
+	}
+	op := hdr[0] & 0x0f
+	masked := hdr[1]&0x80 != 0
+	n := uint64(hdr[1] & 0x7f)
+	switch n {
+	case 126:
+		var l uint16
+		if err := binary.Read(ws.br, binary.BigEndian, &l); err != nil {
+			return 0, nil, err
+		}
+		n = uint64(l)
+	case 127:
+		if err := binary.Read(ws.br, binary.BigEndian, &n); err != nil {
+			return 0, nil, err
+		}
+	}
+	if n > 64<<20 {
+		return 0, nil, fmt.Errorf("websocket frame too large: %d bytes", n)
+	}
+	var mask [4]byte
+	if masked {
+		if _, err := io.ReadFull(ws.br, mask[:]); err != nil {
+			return 0, nil, err
+		}
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(ws.br, payload); err != nil {
+		return 0, nil, err
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return op, payload, nil
+}
+
+// writeFrame sends a single final frame, client frames must be masked.
+func (ws *wsConn) writeFrame(op byte, payload []byte) error {
+	frame := []byte{0x80 | op}
+	n := len(payload)
+	switch {
+	case n < 126:
+		frame = append(frame, 0x80|byte(n))
+	case n <= 0xffff:
+		frame = append(frame, 0x80|126, byte(n>>8), byte(n))
+	default:
+		frame = append(frame, 0x80|127)
+		var l [8]byte
+		binary.BigEndian.PutUint64(l[:], uint64(n))
+		frame = append(frame, l[:]...)
+	}
+	var mask [4]byte
+	rand.Read(mask[:])
+	frame = append(frame, mask[:]...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+
+	ws.wmu.Lock()
+	defer ws.wmu.Unlock()
+	_, err := ws.Conn.Write(frame)
+	return err
+}